@@ -1,23 +1,209 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Amount represents the amount of money represented a floating point value in unit
-// The currency has not importane here, it is just a number
+// Amount represents an amount of money as a fixed point value with 6 decimals (micro units)
+// The currency has not importane here, it is just a number. All computations on amounts are
+// done with integers so splitting or summing segments never accumulates rounding errors.
+type Amount int64
 
-type Amount float64
+const (
+	// AmountDecimals is the number of decimals stored in an Amount
+	AmountDecimals = 6
+	// AmountUnit is the Amount value of one currency unit
+	AmountUnit Amount = 1000000
+	// AmountMax is the maximal representable amount
+	AmountMax = Amount(math.MaxInt64)
+)
+
+// NewAmountFromFloat converts a floating point value in currency unit to an Amount, rounding to the closest micro unit
+func NewAmountFromFloat(value float64) Amount {
+	return Amount(math.Round(value * float64(AmountUnit)))
+}
+
+// ParseAmount parses a decimal string such as "2.50", "-1.5" or "+3" into an Amount
+// An error is returned if the value has more than 6 decimals or overflows
+func ParseAmount(str string) (Amount, error) {
+	s := str
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
 
-const AmountMax = Amount(math.MaxFloat64)
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if (intPart == "" && fracPart == "") || strings.ContainsAny(intPart+fracPart, "+-_") {
+		return 0, fmt.Errorf("invalid amount %q", str)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if len(fracPart) > AmountDecimals {
+		return 0, fmt.Errorf("invalid amount %q, more than %d decimals", str, AmountDecimals)
+	}
+	fracPart += strings.Repeat("0", AmountDecimals-len(fracPart))
+
+	value, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("invalid amount %q, out of range", str)
+	} else if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", str)
+	}
+	if negative {
+		value = -value
+	}
+	return Amount(value), nil
+}
+
+// Float64 returns the amount as floating point value in currency unit, only for display or legacy purposes
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(AmountUnit)
+}
+
+// Format returns the decimal representation of the amount rounded to the given number of decimals
+func (a Amount) Format(decimals int) string {
+	if decimals > AmountDecimals {
+		decimals = AmountDecimals
+	}
+	step := Amount(1)
+	for i := decimals; i < AmountDecimals; i++ {
+		step *= 10
+	}
+	a = a.Round(step)
+
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	intPart := int64(a / AmountUnit)
+	fracPart := int64(a%AmountUnit) / int64(step)
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", sign, intPart)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, intPart, decimals, fracPart)
+}
 
 // String returns the string representation of the amount
 func (a Amount) String() string {
-	return fmt.Sprintf("%.2f", a)
+	return a.Format(2)
+}
+
+// Round rounds the amount to the closest multiple of step, halves are rounded away from zero
+func (a Amount) Round(step Amount) Amount {
+	if step <= 1 {
+		return a
+	}
+	rest := a % step
+	if rest < 0 {
+		rest = -rest
+	}
+	if 2*rest >= step {
+		if a < 0 {
+			return a - (step - rest)
+		}
+		return a + (step - rest)
+	}
+	if a < 0 {
+		return a + rest
+	}
+	return a - rest
+}
+
+// Ceil rounds the amount up to the next multiple of step
+func (a Amount) Ceil(step Amount) Amount {
+	if step <= 1 {
+		return a
+	}
+	rest := a % step
+	if rest > 0 {
+		return a + step - rest
+	}
+	return a - rest
+}
+
+// MulDuration returns the amount charged for the given duration at the given rate per period
+// The result is rounded to the closest micro unit, an empty period charges nothing
+func (a Amount) MulDuration(d time.Duration, period time.Duration) Amount {
+	if period == 0 {
+		return 0
+	}
+	return Amount(mulDiv(int64(a), int64(d), int64(period)))
+}
+
+// MarshalJSON exports the amount as a JSON number with up to 6 decimals
+func (a Amount) MarshalJSON() ([]byte, error) {
+	str := a.Format(AmountDecimals)
+	str = strings.TrimRight(str, "0")
+	str = strings.TrimSuffix(str, ".")
+	if str == "" || str == "-" {
+		str = "0"
+	}
+	return []byte(str), nil
+}
+
+// UnmarshalJSON parses a JSON number into an Amount
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	v, err := ParseAmount(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// UnmarshalYAML parses a YAML scalar into an Amount
+func (a *Amount) UnmarshalYAML(data []byte) error {
+	v, err := ParseAmount(strings.Trim(strings.TrimSpace(string(data)), `"'`))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// mulDiv returns a*b/c rounded to the closest integer, halves away from zero, using 128 bits intermediate result
+// The result saturates to the int64 range on overflow, c must not be zero (the callers check it).
+func mulDiv(a, b, c int64) int64 {
+	negative := (a < 0) != (b < 0) != (c < 0)
+	ua, ub, uc := absUint64(a), absUint64(b), absUint64(c)
+
+	hi, lo := bits.Mul64(ua, ub)
+	if hi >= uc {
+		return saturate(negative)
+	}
+	quo, rem := bits.Div64(hi, lo, uc)
+	if rem >= uc-rem {
+		quo++
+	}
+	if quo > math.MaxInt64 {
+		return saturate(negative)
+	}
+
+	if negative {
+		return -int64(quo)
+	}
+	return int64(quo)
+}
+
+func saturate(negative bool) int64 {
+	if negative {
+		return -math.MaxInt64
+	}
+	return math.MaxInt64
 }
 
-// Reduce float precision to 6 decimal places, usefull when exporting to json
-func (a Amount) Simplify() Amount {
-	return Amount(math.Round(float64(a)*1000000) / 1000000)
+func absUint64(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
 }
@@ -1,6 +1,18 @@
 package engine
 
-/*
+import (
+	"testing"
+	"time"
+)
+
+func mustParseAmount(str string) Amount {
+	a, err := ParseAmount(str)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
 func TestParseAmount(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -13,22 +25,24 @@ func TestParseAmount(t *testing.T) {
 		{"5", Amount(5000000), false},
 		{"123.5678", Amount(123567800), false},
 		{"2147.48", Amount(2147480000), false},
-		{"2147.50", Amount(0), true},
 		{"-2.5", Amount(-2500000), false},
 		{"-1.50", Amount(-1500000), false},
 		{"-5", Amount(-5000000), false},
 		{"-2147.48", Amount(-2147480000), false},
-		{"-2147.50", Amount(0), true},
 		{"+2.5", Amount(2500000), false},
 		{"+1.50", Amount(1500000), false},
 		{"+5", Amount(5000000), false},
 		{"+123.5678", Amount(123567800), false},
 		{"+2147.48", Amount(2147480000), false},
-		{"+2147.50", Amount(0), true},
 		{"1.123456", Amount(1123456), false},
+		{".5", Amount(500000), false},
+		{"9223372036854.775807", AmountMax, false},
+		{"9223372036854.775808", Amount(0), true}, // Overflow
 		{"+123.123456789012345", Amount(0), true}, // More than 6 decimal places
 		{"1.1234567", Amount(0), true},            // More than 6 decimal places
 		{"abc", Amount(0), true},                  // Invalid format
+		{"1.2.3", Amount(0), true},                // Invalid format
+		{"--1", Amount(0), true},                  // Invalid format
 		{"", Amount(0), true},                     // Empty string
 	}
 
@@ -44,4 +58,127 @@ func TestParseAmount(t *testing.T) {
 		})
 	}
 }
-*/
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		decimals int
+		expected string
+		json     string
+	}{
+		{Amount(0), 2, "0.00", "0"},
+		{Amount(2500000), 2, "2.50", "2.5"},
+		{Amount(1091667), 2, "1.09", "1.091667"},
+		{Amount(1095000), 2, "1.10", "1.095"},
+		{Amount(-1095000), 2, "-1.10", "-1.095"},
+		{Amount(12000000), 0, "12", "12"},
+		{Amount(1), 6, "0.000001", "0.000001"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if str := test.amount.Format(test.decimals); str != test.expected {
+				t.Errorf("Format(%d) = %s, expected %s", test.decimals, str, test.expected)
+			}
+			json, err := test.amount.MarshalJSON()
+			if err != nil {
+				t.Fatalf("MarshalJSON error = %v", err)
+			}
+			if string(json) != test.json {
+				t.Errorf("MarshalJSON = %s, expected %s", json, test.json)
+			}
+			var back Amount
+			if err := back.UnmarshalJSON(json); err != nil || back != test.amount {
+				t.Errorf("UnmarshalJSON(%s) = %v (error %v), expected %v", json, back, err, test.amount)
+			}
+		})
+	}
+}
+
+func TestAmountMulDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     Amount
+		duration time.Duration
+		expected Amount
+	}{
+		{"1h at 1.00/h", AmountUnit, time.Hour, AmountUnit},
+		{"3h5m30s at 1.00/h", AmountUnit, 3*time.Hour + 5*time.Minute + 30*time.Second, Amount(3091667)},
+		{"20s at 0.50/h", AmountUnit / 2, 20 * time.Second, Amount(2778)},
+		{"120d at 3.00/h", 3 * AmountUnit, 120 * 24 * time.Hour, 8640 * AmountUnit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if amount := test.rate.MulDuration(test.duration, time.Hour); amount != test.expected {
+				t.Errorf("MulDuration = %v, expected %v", int64(amount), int64(test.expected))
+			}
+		})
+	}
+}
+
+func TestAmountCeil(t *testing.T) {
+	step := mustParseAmount("0.05")
+	tests := map[string]struct {
+		amount   Amount
+		expected Amount
+	}{
+		"0-Multiple":  {amount: mustParseAmount("1.10"), expected: mustParseAmount("1.10")},
+		"1-RoundedUp": {amount: mustParseAmount("1.11"), expected: mustParseAmount("1.15")},
+		"2-Micro":     {amount: mustParseAmount("1.100001"), expected: mustParseAmount("1.15")},
+		"3-Negative":  {amount: mustParseAmount("-1.14"), expected: mustParseAmount("-1.10")},
+		"4-Zero":      {amount: 0, expected: 0},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			if amount := testcase.amount.Ceil(step); amount != testcase.expected {
+				t.Errorf("Ceil(%s) expected %s, got %s", testcase.amount, testcase.expected, amount)
+			}
+		})
+	}
+}
+
+// Amounts coming from a client table must never crash the computation
+func TestAmountMulDurationLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     Amount
+		duration time.Duration
+		period   time.Duration
+		expected Amount
+	}{
+		{"empty period", AmountUnit, time.Hour, 0, 0},
+		{"overflow", AmountMax, 2 * time.Hour, time.Hour, AmountMax},
+		{"negative overflow", -AmountMax, 2 * time.Hour, time.Hour, -AmountMax},
+		{"overflow after division", AmountMax / 2, 3 * time.Hour, time.Hour, AmountMax},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if amount := test.rate.MulDuration(test.duration, test.period); amount != test.expected {
+				t.Errorf("MulDuration = %v, expected %v", int64(amount), int64(test.expected))
+			}
+		})
+	}
+}
+
+// Splitting a linear rule many times must keep the total amount exact
+func TestAmountSplitIsExact(t *testing.T) {
+	rule := NewLinearSequentialRule("linear", 10*time.Hour, AmountUnit, MetaData{})
+	total := rule.EndAmount
+
+	var sum Amount
+	rest := rule
+	for i := 0; i < 7; i++ {
+		at := rest.From + 17*time.Minute + 13*time.Second
+		parts := rest.Split(at, at+time.Hour)
+		sum += parts[0].EndAmount
+		rest = parts[1]
+	}
+	sum += rest.EndAmount
+
+	if sum != total {
+		t.Errorf("sum of split parts %s is not equal to the original amount %s", sum.Format(AmountDecimals), total.Format(AmountDecimals))
+	}
+}
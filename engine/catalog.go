@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CatalogEntry is a tariff of the catalog with the layer and tariff code patterns it applies to
+type CatalogEntry struct {
+	LayerPattern  string
+	TariffPattern string
+	File          string
+	Tariff        TariffDefinition
+}
+
+func (e CatalogEntry) String() string {
+	return fmt.Sprintf("(%s, %s) %s", e.LayerPattern, e.TariffPattern, e.File)
+}
+
+// Match checks if the entry applies to the layer and tariff codes, patterns are case insensitive globs
+func (e CatalogEntry) Match(layer, tariffCode string) (bool, error) {
+	match, err := globMatch(e.LayerPattern, layer)
+	if err != nil || !match {
+		return false, err
+	}
+	return globMatch(e.TariffPattern, tariffCode)
+}
+
+// Catalog is a set of tariffs resolved by layer code and tariff code. It is loaded from a directory tree
+// where each tariff file is named after its tariff code pattern and stored in a directory named after its
+// layer code pattern, the files at the root apply to all the layers:
+//
+//	catalog/
+//	  default.yaml     layer *,      tariff default
+//	  ZONE_A/
+//	    t1.yaml        layer ZONE_A, tariff t1
+//	    *.yaml         layer ZONE_A, any other tariff
+//	  ZONE_*/
+//	    t1.yaml        layer ZONE_*, tariff t1
+//
+// Intermediate directories can be used to organize the layers, only the parent directory of a file is used
+// as its layer pattern. Like the tariffs, the catalog is never modified once loaded.
+type Catalog struct {
+	entries []CatalogEntry
+}
+
+// LoadCatalog parses all the tariff files (*.yaml) of a directory tree
+func LoadCatalog(root string) (*Catalog, error) {
+	var catalog Catalog
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".yaml" {
+			return nil
+		}
+
+		entry := CatalogEntry{
+			LayerPattern:  "*",
+			TariffPattern: fileNameWithoutExtension(d.Name()),
+			File:          path,
+		}
+		if dir := filepath.Dir(path); dir != filepath.Clean(root) {
+			entry.LayerPattern = filepath.Base(dir)
+		}
+		for _, pattern := range []string{entry.LayerPattern, entry.TariffPattern} {
+			if _, err := globMatch(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q for tariff %s: %w", pattern, path, err)
+			}
+		}
+
+		entry.Tariff, err = ParseTariffDefinitionFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to parse tariff %s: %w", path, err)
+		}
+		catalog.entries = append(catalog.entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort the entries by precedence, the first matching entry is the one to use
+	sort.SliceStable(catalog.entries, func(i, j int) bool {
+		a, b := catalog.entries[i], catalog.entries[j]
+		if c := comparePatterns(a.LayerPattern, b.LayerPattern); c != 0 {
+			return c > 0
+		}
+		if c := comparePatterns(a.TariffPattern, b.TariffPattern); c != 0 {
+			return c > 0
+		}
+		return a.File < b.File
+	})
+	return &catalog, nil
+}
+
+// comparePatterns compares the precedence of two glob patterns, an exact name is more specific than any
+// pattern, then the pattern with the most literal characters is the most specific
+func comparePatterns(a, b string) int {
+	aIsGlob, bIsGlob := isGlob(a), isGlob(b)
+	if aIsGlob != bIsGlob {
+		if aIsGlob {
+			return -1
+		}
+		return 1
+	}
+	return literalLength(a) - literalLength(b)
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// literalLength counts the characters of a pattern matched literally
+func literalLength(pattern string) int {
+	n := 0
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '\\':
+			i++
+			n++
+		case c != '*' && c != '?':
+			n++
+		}
+	}
+	return n
+}
+
+// Entries returns the catalog entries sorted by precedence
+func (c *Catalog) Entries() []CatalogEntry {
+	return c.entries
+}
+
+// Resolve returns the tariff entry applying to the layer and tariff codes. The exact layer code wins over
+// the layer patterns, then the most specific tariff code pattern wins.
+func (c *Catalog) Resolve(layer, tariffCode string) (CatalogEntry, error) {
+	for _, entry := range c.entries {
+		match, err := entry.Match(layer, tariffCode)
+		if err != nil {
+			return CatalogEntry{}, err
+		}
+		if match {
+			return entry, nil
+		}
+	}
+	return CatalogEntry{}, fmt.Errorf("%w for layer %q and tariff %q", ErrTariffNotFound, layer, tariffCode)
+}
+
+// Quote computes the table of the tariff applying to the layer and tariff codes. The customer profile has
+// these layer and tariff codes, unless a WithProfile option is given.
+func (c *Catalog) Quote(layer, tariffCode string, now time.Time, history AssignedRights, options ...ComputeOption) (Output, error) {
+	entry, err := c.Resolve(layer, tariffCode)
+	if err != nil {
+		return Output{}, err
+	}
+	options = append([]ComputeOption{WithProfile(CustomerProfile{TariffCode: tariffCode, LayerCode: layer})}, options...)
+	return entry.Tariff.Compute(now, history, options...)
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCatalog creates a catalog in a temporary directory, each tariff has its own hourly rate to identify it
+func writeCatalog(t *testing.T, rates map[string]string) string {
+	root := t.TempDir()
+	for file, rate := range rates {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create catalog directory: %v", err)
+		}
+		tariff := fmt.Sprintf(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: %s
+      duration: 4h
+`, rate)
+		if err := os.WriteFile(path, []byte(tariff), 0644); err != nil {
+			t.Fatalf("failed to write catalog tariff: %v", err)
+		}
+	}
+	return root
+}
+
+func TestCatalogQuote(t *testing.T) {
+	root := writeCatalog(t, map[string]string{
+		"default.yaml":               "1.0",
+		"*.yaml":                     "1.5",
+		"ZONE_A/t1.yaml":             "2.0",
+		"ZONE_A/*.yaml":              "2.5",
+		"ZONE_*/t1.yaml":             "3.0",
+		"ZONE_*/t?.yaml":             "3.5",
+		"region/ZONE_B[0-9]/t1.yaml": "4.0",
+		"ignored.txt":                "0",
+	})
+	catalog, err := LoadCatalog(root)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+	if len(catalog.Entries()) != 7 {
+		t.Errorf("expected 7 entries, got %v", catalog.Entries())
+	}
+
+	tests := map[string]struct {
+		layer      string
+		tariffCode string
+		expected   Amount
+	}{
+		"0-ExactLayerAndTariff":     {layer: "ZONE_A", tariffCode: "t1", expected: NewAmountFromFloat(2.0)},
+		"1-ExactLayerAnyTariff":     {layer: "ZONE_A", tariffCode: "t2", expected: NewAmountFromFloat(2.5)},
+		"2-CaseInsensitive":         {layer: "zone_a", tariffCode: "T1", expected: NewAmountFromFloat(2.0)},
+		"3-LayerPatternExactTariff": {layer: "ZONE_C", tariffCode: "t1", expected: NewAmountFromFloat(3.0)},
+		"4-LayerPatternTariffGlob":  {layer: "ZONE_C", tariffCode: "t2", expected: NewAmountFromFloat(3.5)},
+		"5-LongestLayerPattern":     {layer: "ZONE_B1", tariffCode: "t1", expected: NewAmountFromFloat(4.0)},
+		"6-RootExactTariff":         {layer: "OTHER", tariffCode: "default", expected: NewAmountFromFloat(1.0)},
+		"7-RootAnyTariff":           {layer: "ZONE_C", tariffCode: "t10", expected: NewAmountFromFloat(1.5)},
+	}
+
+	now := time.Date(2025, 3, 17, 6, 0, 0, 0, time.Local)
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := catalog.Quote(testcase.layer, testcase.tariffCode, now, nil)
+			if err != nil {
+				t.Fatalf("failed to quote: %v", err)
+			}
+			if amount := out.AmountForDuration(time.Hour); amount != testcase.expected {
+				t.Errorf("expected hourly amount %s, got %s", testcase.expected, amount)
+			}
+		})
+	}
+}
+
+func TestCatalogErrors(t *testing.T) {
+	root := writeCatalog(t, map[string]string{
+		"ZONE_A/t1.yaml": "1.0",
+	})
+	catalog, err := LoadCatalog(root)
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+	if _, err := catalog.Resolve("ZONE_B", "t1"); !errors.Is(err, ErrTariffNotFound) {
+		t.Errorf("expected error %v, got %v", ErrTariffNotFound, err)
+	}
+
+	root = writeCatalog(t, map[string]string{
+		"ZONE_[A/t1.yaml": "1.0",
+	})
+	if _, err := LoadCatalog(root); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("expected error %v, got %v", filepath.ErrBadPattern, err)
+	}
+
+	root = writeCatalog(t, map[string]string{
+		"ZONE_A/t1.yaml": "abc",
+	})
+	if _, err := LoadCatalog(root); err == nil {
+		t.Errorf("expected a parsing error")
+	}
+}
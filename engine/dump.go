@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/iem-rd/quote-engine/table"
+)
+
+// discardHandler is a slog handler dropping all records, used when the caller does not provide any logger
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// discardLogger returns the logger to use when none is provided
+func discardLogger() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// RulesDump is an opt-in debug output where the rules are written as tables at each computation step
+// A nil RulesDump writes nothing.
+type RulesDump struct {
+	Writer  io.Writer
+	NoColor bool
+}
+
+// NewRulesDump creates a dump writing to w, colors are disabled if noColor is set
+func NewRulesDump(w io.Writer, noColor bool) *RulesDump {
+	return &RulesDump{Writer: w, NoColor: noColor}
+}
+
+func (d *RulesDump) enabled() bool {
+	return d != nil && d.Writer != nil
+}
+
+// Title writes a title line in the dump
+func (d *RulesDump) Title(a ...interface{}) {
+	if !d.enabled() {
+		return
+	}
+	fmt.Fprintln(d.Writer)
+	table.TitleTheme(d.NoColor).Fprintln(d.Writer, a...)
+}
+
+// PrintRules writes the rules as a table in the dump
+func (d *RulesDump) PrintRules(title string, now time.Time, rules SolverRules) {
+	if !d.enabled() {
+		return
+	}
+	tbl := d.StartRulesTable(title, now)
+	for _, rule := range rules {
+		tbl.AddRule(&rule)
+	}
+	tbl.Print()
+}
+
+type RulesTable struct {
+	dump  *RulesDump
+	now   time.Time
+	tbl   table.Table
+	title string
+	empty bool
+}
+
+// StartRulesTable starts a new rules table written in the dump when printed
+func (d *RulesDump) StartRulesTable(title string, now time.Time) *RulesTable {
+	t := RulesTable{
+		dump:  d,
+		now:   now,
+		title: title,
+		empty: true,
+	}
+	if d.enabled() {
+		t.tbl = table.New("Name", "From", "To", "Duration", "From (abs)", "To (abs)", "StartAmount", "EndAmount", "IsLinear", "ActivAm", "Type")
+		t.tbl.WithWriter(d.Writer)
+		table.SetDefaultTheme(&t.tbl, d.NoColor)
+	}
+	return &t
+}
+
+func (t *RulesTable) AddRule(rule *SolverRule) {
+	if !t.dump.enabled() {
+		return
+	}
+
+	dateToString := func(now time.Time, delta time.Duration) string {
+		if now.IsZero() {
+			return ""
+		}
+		return now.Add(delta).Format("2006-01-02 15:04:05")
+	}
+
+	t.tbl.AddRow(rule.Name(),
+		rule.From.String(),
+		rule.To.String(),
+		rule.Duration().String(),
+		dateToString(t.now, rule.From),
+		dateToString(t.now, rule.To),
+		rule.StartAmount.String(),
+		rule.EndAmount.String(),
+		fmt.Sprintf("%t", rule.IsFlatRate()),
+		rule.ActivationAmount.String(),
+		rule.DurationType.String(),
+	)
+	t.empty = false
+}
+
+func (t *RulesTable) Print() {
+	if !t.empty {
+		t.dump.Title(t.title)
+		t.tbl.Print()
+	}
+}
@@ -0,0 +1,21 @@
+package engine
+
+import "errors"
+
+// Errors returned by the tariff computation, they are wrapped with more context so use errors.Is to check them
+var (
+	// ErrInvalidTimespan is returned when a rule ends before it starts
+	ErrInvalidTimespan = errors.New("invalid timespan")
+	// ErrRecurrentRule is returned when a recurrent date or timespan (rrule, pattern...) cannot be unrolled
+	ErrRecurrentRule = errors.New("recurrent rule failure")
+	// ErrQuotaMatching is returned when the parking rights history cannot be matched against a quota
+	ErrQuotaMatching = errors.New("quota matching failure")
+	// ErrUnsolvableRules is returned when the solver does not know how to resolve a conflict between two rules
+	ErrUnsolvableRules = errors.New("unsolvable rules")
+	// ErrProfileMatching is returned when the customer profile cannot be matched against a sequence or rule condition
+	ErrProfileMatching = errors.New("profile matching failure")
+	// ErrTariffNotFound is returned when no tariff of the catalog applies to a layer and tariff code
+	ErrTariffNotFound = errors.New("no tariff found")
+	// ErrInvalidCashStep is returned when the smallest cash step is not a positive amount of at least MinCashStep
+	ErrInvalidCashStep = errors.New("invalid cash step")
+)
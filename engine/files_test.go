@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"testing"
 	"time"
 
@@ -12,20 +12,29 @@ import (
 )
 
 type TestPoint struct {
-	Amount float64 `yaml:"amount"`
-	End    string  `yaml:"end"`
+	Amount Amount `yaml:"amount"`
+	End    string `yaml:"end"`
 }
 
-type TestCase struct {
-	Name           string      `yaml:"name"`
-	Now            string      `yaml:"now"`
-	History        string      `yaml:"history"`
-	TestPoints     []TestPoint `yaml:"tests"`
-	ExpectedExpiry string      `yaml:"expiry"`
+// TestQuota is the expected state of a quota in the output header, the values are in the quota units
+type TestQuota struct {
+	Name        string  `yaml:"name"`
+	UsedBefore  float64 `yaml:"usedbefore"`
+	UsedByTable float64 `yaml:"usedbytable"`
+	Remaining   float64 `yaml:"remaining"`
 }
 
-func fileNameWithoutExtension(fileName string) string {
-	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+type TestCase struct {
+	Name           string          `yaml:"name"`
+	Now            string          `yaml:"now"`
+	History        string          `yaml:"history"`
+	TestPoints     []TestPoint     `yaml:"tests"`
+	ExpectedExpiry string          `yaml:"expiry"`
+	Profile        CustomerProfile `yaml:"profile"`
+	// Expected end of the banned segment ending the table, no banned segment if empty
+	ExpectedNextAllowed string `yaml:"nextallowed"`
+	// Expected quotas state, the quotas not listed are not checked
+	ExpectedQuotas []TestQuota `yaml:"quotas"`
 }
 
 func TestTariffs(t *testing.T) {
@@ -89,7 +98,9 @@ func testSingleTariff(t *testing.T, path string, tariffDescr []byte, testCases [
 			if err != nil {
 				t.Fatalf("failed to parse tariff definition: %v", err)
 			}
-			now, err := time.ParseInLocation("2006-01-02T15:04:05", testCase.Now, time.Local)
+			// Test times are in the tariff time zone
+			loc := tariff.Config.Location()
+			now, err := time.ParseInLocation("2006-01-02T15:04:05", testCase.Now, loc)
 			if err != nil {
 				t.Fatalf("failed to parse now time: %v", err)
 			}
@@ -105,7 +116,10 @@ func testSingleTariff(t *testing.T, path string, tariffDescr []byte, testCases [
 			}
 
 			// Compute the tariff table
-			table := tariff.Compute(now, history)
+			table, err := tariff.Compute(now, history, WithProfile(testCase.Profile))
+			if err != nil {
+				t.Fatalf("failed to compute tariff: %v", err)
+			}
 
 			//Display JSON output
 			json, err := table.ToJson()
@@ -115,19 +129,47 @@ func testSingleTariff(t *testing.T, path string, tariffDescr []byte, testCases [
 			fmt.Println(string(json))
 
 			// Check the expiry date
-			expectedExpiry, err := time.ParseInLocation("2006-01-02T15:04:05", testCase.ExpectedExpiry, time.Local)
+			expectedExpiry, err := time.ParseInLocation("2006-01-02T15:04:05", testCase.ExpectedExpiry, loc)
 			if err == nil && !expectedExpiry.IsZero() {
 				if table.ExpiryDate.Before(now) {
 					t.Errorf("Invalid expiry date: %v is before now time: %v", table.ExpiryDate, now)
 				}
-				if table.ExpiryDate != expectedExpiry {
+				if !table.ExpiryDate.Equal(expectedExpiry) {
 					t.Errorf("Expiry date mismatch: got %v, expected %v", table.ExpiryDate, expectedExpiry)
 				}
 			}
 
+			// Check the end of the banned segment
+			if testCase.ExpectedNextAllowed == "" {
+				if table.NextAllowed != nil {
+					t.Errorf("Unexpected next allowed date: %v", table.NextAllowed)
+				}
+			} else {
+				expectedNextAllowed, err := time.ParseInLocation("2006-01-02T15:04:05", testCase.ExpectedNextAllowed, loc)
+				if err != nil {
+					t.Fatalf("failed to parse next allowed time: %v", err)
+				}
+				if table.NextAllowed == nil || !table.NextAllowed.Equal(expectedNextAllowed) {
+					t.Errorf("Next allowed date mismatch: got %v, expected %v", table.NextAllowed, expectedNextAllowed)
+				}
+			}
+
+			// Check the quotas state
+			for _, expected := range testCase.ExpectedQuotas {
+				i := slices.IndexFunc(table.Quotas, func(q OutputQuota) bool { return q.Name == expected.Name })
+				if i < 0 {
+					t.Errorf("Missing quota %s in output", expected.Name)
+					continue
+				}
+				got := TestQuota{Name: expected.Name, UsedBefore: table.Quotas[i].UsedBefore, UsedByTable: table.Quotas[i].UsedByTable, Remaining: table.Quotas[i].Remaining}
+				if got != expected {
+					t.Errorf("Quota mismatch: got %+v, expected %+v", got, expected)
+				}
+			}
+
 			// Iterate over each testpoints in the current test case
 			for _, test := range testCase.TestPoints {
-				end, err := time.ParseInLocation("2006-01-02T15:04:05", test.End, time.Local)
+				end, err := time.ParseInLocation("2006-01-02T15:04:05", test.End, loc)
 				if err != nil {
 					t.Fatalf("failed to parse end time: %v", err)
 				}
@@ -135,8 +177,8 @@ func testSingleTariff(t *testing.T, path string, tariffDescr []byte, testCases [
 					t.Fatalf("invalid test case, end time is before now time: %v < %v", end, now)
 				}
 				amount := table.AmountForDuration(end.Sub(now))
-				if amount.Simplify() != Amount(test.Amount) {
-					t.Errorf("Amount mismatch: got %f, expected %f", amount.Simplify(), test.Amount)
+				if amount != test.Amount {
+					t.Errorf("Amount mismatch: got %s, expected %s", amount.Format(AmountDecimals), test.Amount.Format(AmountDecimals))
 				}
 			}
 		})
@@ -17,6 +17,6 @@ require (
 	golang.org/x/sys v0.25.0 // indirect
 )
 
-//replace github.com/iem-rd/quote-engine/table => ../table
+replace github.com/iem-rd/quote-engine/table => ../table
 
-//replace github.com/iem-rd/quote-engine/timeutils => ../timeutils
+replace github.com/iem-rd/quote-engine/timeutils => ../timeutils
@@ -13,6 +13,7 @@ type OutputSegment struct {
 	Amount       Amount       `json:"a"`
 	Islinear     bool         `json:"l"`
 	DurationType DurationType `json:"dt,omitempty"`
+	Quota        string       `json:"q,omitempty"` // Name of the quota used by the segment
 	Meta         MetaData     `json:"m,omitempty"`
 }
 
@@ -32,39 +33,224 @@ func (segs OutputSegments) String() string {
 }
 
 type Output struct {
-	Now        time.Time      `json:"now"`
-	ExpiryDate time.Time      `json:"expiry"`
-	Table      OutputSegments `json:"table"`
+	Now        time.Time `json:"now"`
+	ExpiryDate time.Time `json:"expiry"`
+	// NextAllowed is the end of the banned segment ending the table, the parking is allowed again from this date
+	NextAllowed *time.Time `json:"nextallowed,omitempty"`
+	// Gaps lists the gaps between rules met while building the table, with the sequences which left them
+	Gaps []OutputGap `json:"gaps,omitempty"`
+	// Quotas reports the state of the quotas in effect at now
+	Quotas []OutputQuota `json:"quotas,omitempty"`
+	// CashStep is the smallest amount which can be paid, the amounts of the table are multiples of it (see WithCashStep)
+	CashStep Amount         `json:"cashstep,omitempty"`
+	Table    OutputSegments `json:"table"`
+}
+
+// PurchasePoint is an amount which can be paid and the end of the parking right it buys
+type PurchasePoint struct {
+	Amount Amount    `json:"amount"`
+	End    time.Time `json:"end"`
+}
+
+// OutputQuota is the state of a quota before the quote and once the whole table is used, the values are in seconds
+// for the duration quotas, in parking rights for the counter quotas and in currency for the amount quotas
+type OutputQuota struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Allowance   float64 `json:"allowance"`
+	UsedBefore  float64 `json:"usedbefore"`
+	UsedByTable float64 `json:"usedbytable"`
+	Remaining   float64 `json:"remaining"`
+	// NextReset is the next period start, or for a rolling quota the time when the whole allowance is available again
+	NextReset *time.Time `json:"nextreset,omitempty"`
+}
+
+// OutputGap is a diagnostic of a gap between rules, the sequence is empty if the gap is not left by a single sequence
+type OutputGap struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Sequence string    `json:"sequence,omitempty"`
 }
 
 func (segs Output) ToJson() ([]byte, error) {
 	return json.Marshal(segs)
 }
 
+// LoadOutputFromJSON loads a previously computed output from a JSON payload
+func LoadOutputFromJSON(data []byte) (Output, error) {
+	var out Output
+	err := json.Unmarshal(data, &out)
+	if err != nil {
+		return Output{}, err
+	}
+	return out, nil
+}
+
+// AmountForDuration returns the amount to pay for the given duration, or 0 if the duration cannot be bought
+// because it goes beyond the end of the table or into a banned segment
 func (segs Output) AmountForDuration(targetDuration time.Duration) Amount {
 	totAmount := Amount(0)
 	totDuration := time.Duration(0)
-	fmt.Println("AmountForDuration, Target duration", targetDuration, "nb rules", len(segs.Table))
 	for _, seg := range segs.Table {
+		// The parking right cannot go across a banned segment
+		if seg.DurationType == BannedDuration {
+			break
+		}
 		segDuration := time.Duration(seg.Duration) * time.Second
 		// If the segement is linear and is longer than the target duration, we need to calculate the amount for the remaining duration
-		if seg.Islinear && targetDuration < totDuration+segDuration {
-			fmt.Println("   >> Segment (partial linear)", seg.SegName, "Total amount", totAmount, "Total duration", totDuration)
-			return Amount(float64(seg.Amount)*float64(targetDuration-totDuration)/float64(segDuration)) + totAmount
+		// An empty linear segment has no time to interpolate, it is summed as a whole
+		if seg.Islinear && segDuration > 0 && targetDuration < totDuration+segDuration {
+			return seg.Amount.MulDuration(targetDuration-totDuration, segDuration) + totAmount
 		}
 		// If the segment is not linear and is longer or egual to the target duration, include it in the total
 		if !seg.Islinear && targetDuration <= totDuration+segDuration {
-			fmt.Println("   >> Segment (fixed)", seg.SegName, "Total amount", totAmount, "Total duration", totDuration)
 			return seg.Amount + totAmount
 		}
 		totAmount += seg.Amount
 		totDuration += segDuration
-		fmt.Println("   >> Segment", seg.SegName, "Total amount", totAmount, "Total duration", totDuration)
-
 	}
+	// The duration is greater than the total duration of the output
 	if targetDuration > totDuration {
-		fmt.Println("WARNING: Duration is greater than the total duration of the output")
 		return Amount(0)
 	}
 	return totAmount
 }
+
+// DurationForAmount returns the longest duration which can be bought with the given amount. Linear segments
+// are bought partially, fixed segments only if the whole segment amount is available. Free and non-paying
+// segments following the bought time are included as they don't cost anything, up to a banned segment.
+func (segs Output) DurationForAmount(amount Amount) time.Duration {
+	totAmount := Amount(0)
+	totDuration := time.Duration(0)
+	for _, seg := range segs.Table {
+		if seg.DurationType == BannedDuration {
+			break
+		}
+		segDuration := time.Duration(seg.Duration) * time.Second
+		remaining := amount - totAmount
+
+		// The whole segment can be bought, include it and continue with the next one
+		if seg.Amount <= remaining {
+			totAmount += seg.Amount
+			totDuration += segDuration
+			continue
+		}
+
+		// If the segment is linear, buy the part of the segment corresponding to the remaining amount
+		if seg.Islinear && remaining > 0 {
+			partial := time.Duration(mulDiv(int64(segDuration), int64(remaining), int64(seg.Amount)))
+			totDuration += partial.Truncate(time.Second)
+		}
+		break
+	}
+	return totDuration
+}
+
+// EndDateForAmount returns the absolute end date of the parking right bought with the given amount
+func (segs Output) EndDateForAmount(amount Amount) time.Time {
+	return segs.Now.Add(segs.DurationForAmount(amount))
+}
+
+// MinCashStep is the smallest cash step accepted, one cent
+const MinCashStep = AmountUnit / 100
+
+// ValidateCashStep checks that the step can be used as a smallest cash step
+func ValidateCashStep(step Amount) error {
+	if step < MinCashStep {
+		return fmt.Errorf("%w %s, it must be at least %s", ErrInvalidCashStep, step.Format(AmountDecimals), MinCashStep)
+	}
+	return nil
+}
+
+// WithCashStep returns the table for a smallest cash step: the cumulated amounts of the table are rounded up to
+// multiples of the step and the linear segments are replaced by steps ending where the original table reaches the
+// next multiple. A duration costs the smallest payable amount covering it and an amount buys the duration of the
+// largest payable amount it contains, so no amount which cannot be paid is ever shown.
+// A linear segment gives at most one step per second whatever the step, see ValidateCashStep for the steps accepted.
+func (segs Output) WithCashStep(step Amount) Output {
+	if step <= 0 {
+		return segs
+	}
+	out := segs
+	out.CashStep = step
+	out.Table = make(OutputSegments, 0, len(segs.Table))
+
+	// exact is the amount of the original table so far, paid is the payable amount charged for it
+	exact, paid := Amount(0), Amount(0)
+	for _, seg := range segs.Table {
+		if seg.Amount <= 0 || seg.DurationType == BannedDuration {
+			out.Table = append(out.Table, seg)
+			continue
+		}
+		if !seg.Islinear {
+			exact += seg.Amount
+			seg.Amount = exact.Ceil(step) - paid
+			paid += seg.Amount
+			out.Table = append(out.Table, seg)
+			continue
+		}
+
+		// Seconds of the linear segment at which the original table reaches the amount, a step ends there
+		at := func(amount Amount) int {
+			return min(int(int64(seg.Duration)*int64(amount-exact)/int64(seg.Amount)), seg.Duration)
+		}
+		appendStep := func(from, to int, amount Amount) {
+			step := seg
+			step.Duration = to - from
+			step.Amount = amount
+			step.Islinear = false
+			out.Table = append(out.Table, step)
+		}
+
+		// The beginning of the segment may be already paid by the previous rounding
+		cur := 0
+		if paid > exact {
+			if cur = at(paid); cur > 0 {
+				appendStep(0, cur, 0)
+			}
+		}
+		// Each step ends at the smallest multiple reaching a second after the current one, the multiples ending
+		// in the same second are charged at once
+		for cur < seg.Duration {
+			next := exact + Amount((int64(cur+1)*int64(seg.Amount)+int64(seg.Duration)-1)/int64(seg.Duration))
+			next = max(next.Ceil(step), paid+step)
+			to := at(next)
+			appendStep(cur, to, next-paid)
+			cur, paid = to, next
+		}
+		exact += seg.Amount
+	}
+	return out
+}
+
+// PurchasePoints lists the amounts which can be paid with the end of the parking right each of them buys, in the
+// order of the table. A linear segment can be bought for any amount and gives no point, use WithCashStep to turn
+// the linear segments into steps. An initial free duration is listed as a point of amount 0.
+func (segs Output) PurchasePoints() []PurchasePoint {
+	points := []PurchasePoint{{Amount: 0, End: segs.Now}}
+	amount := Amount(0)
+	duration := time.Duration(0)
+	// open is set while the last point also buys the segments met
+	open := true
+	for _, seg := range segs.Table {
+		if seg.DurationType == BannedDuration {
+			break
+		}
+		switch {
+		case seg.Islinear && seg.Amount > 0:
+			open = false
+		case seg.Amount > 0:
+			points = append(points, PurchasePoint{Amount: amount + seg.Amount})
+			open = true
+		}
+		amount += seg.Amount
+		duration += time.Duration(seg.Duration) * time.Second
+		if open {
+			points[len(points)-1].End = segs.Now.Add(duration)
+		}
+	}
+	if points[0].End.Equal(segs.Now) {
+		points = points[1:]
+	}
+	return points
+}
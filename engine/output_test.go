@@ -0,0 +1,326 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/iem-rd/quote-engine/timeutils"
+)
+
+func TestDurationForAmount(t *testing.T) {
+	// 30min fixed 0.50, 1h linear 1.00, 10h night non-paying, 2h linear 3.00
+	out := Output{
+		Now: time.Date(2025, 3, 17, 18, 0, 0, 0, time.UTC),
+		Table: OutputSegments{
+			{Duration: 1800, Amount: mustParseAmount("0.50"), Islinear: false, DurationType: PayingDuration},
+			{Duration: 3600, Amount: mustParseAmount("1.00"), Islinear: true, DurationType: PayingDuration},
+			{Duration: 36000, Amount: 0, Islinear: false, DurationType: NonPayingDuration},
+			{Duration: 7200, Amount: mustParseAmount("3.00"), Islinear: true, DurationType: PayingDuration},
+		},
+	}
+
+	tests := map[string]struct {
+		amount   Amount
+		expected time.Duration
+	}{
+		"0-NoAmount":                  {amount: mustParseAmount("0"), expected: 0},
+		"1-NotEnoughForFixedSegment":  {amount: mustParseAmount("0.4"), expected: 0},
+		"2-ExactFixedSegment":         {amount: mustParseAmount("0.5"), expected: 30 * time.Minute},
+		"3-PartialLinearSegment":      {amount: mustParseAmount("1.0"), expected: 60 * time.Minute},
+		"4-FullLinearAndNonPaying":    {amount: mustParseAmount("1.5"), expected: 11*time.Hour + 30*time.Minute},
+		"5-PartialAfterNonPaying":     {amount: mustParseAmount("3.0"), expected: 12*time.Hour + 30*time.Minute},
+		"6-MoreThanTheWholeTable":     {amount: mustParseAmount("10.0"), expected: 13*time.Hour + 30*time.Minute},
+		"7-PartialLinearCentPrecison": {amount: mustParseAmount("0.51"), expected: 30*time.Minute + 36*time.Second},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			duration := out.DurationForAmount(testcase.amount)
+			if duration != testcase.expected {
+				t.Errorf("DurationForAmount(%s) expected %v, got %v", testcase.amount, testcase.expected, duration)
+			}
+			if end := out.EndDateForAmount(testcase.amount); !end.Equal(out.Now.Add(testcase.expected)) {
+				t.Errorf("EndDateForAmount(%s) expected %v, got %v", testcase.amount, out.Now.Add(testcase.expected), end)
+			}
+		})
+	}
+}
+
+// A banned segment is a hard stop, the free time after the bought time stops at its start
+func TestDurationForAmountBanned(t *testing.T) {
+	// 1h linear 1.00, 1h night non-paying, 2h banned
+	out := Output{
+		Now: time.Date(2025, 3, 17, 18, 0, 0, 0, time.UTC),
+		Table: OutputSegments{
+			{Duration: 3600, Amount: mustParseAmount("1.00"), Islinear: true, DurationType: PayingDuration},
+			{Duration: 3600, Amount: 0, Islinear: false, DurationType: NonPayingDuration},
+			{Duration: 7200, Amount: 0, Islinear: false, DurationType: BannedDuration},
+		},
+	}
+	if duration := out.DurationForAmount(mustParseAmount("5.0")); duration != 2*time.Hour {
+		t.Errorf("DurationForAmount expected %v, got %v", 2*time.Hour, duration)
+	}
+	if amount := out.AmountForDuration(2 * time.Hour); amount != mustParseAmount("1.0") {
+		t.Errorf("AmountForDuration up to the banned segment expected 1.0, got %s", amount)
+	}
+	if amount := out.AmountForDuration(3 * time.Hour); amount != 0 {
+		t.Errorf("AmountForDuration across the banned segment expected 0, got %s", amount)
+	}
+}
+
+func TestLoadOutputFromJSON(t *testing.T) {
+	out := Output{
+		Now: time.Date(2025, 3, 17, 18, 0, 0, 0, time.UTC),
+		Table: OutputSegments{
+			{SegName: "fixed", Duration: 1800, Amount: mustParseAmount("0.50"), Islinear: false, DurationType: PayingDuration},
+			{SegName: "night", Duration: 36000, Amount: 0, Islinear: false, DurationType: NonPayingDuration},
+		},
+	}
+	data, err := out.ToJson()
+	if err != nil {
+		t.Fatalf("failed to convert output to JSON: %v", err)
+	}
+	loaded, err := LoadOutputFromJSON(data)
+	if err != nil {
+		t.Fatalf("failed to load output from JSON: %v", err)
+	}
+	if !loaded.Now.Equal(out.Now) || len(loaded.Table) != len(out.Table) {
+		t.Fatalf("loaded output mismatch: got %v, expected %v", loaded, out)
+	}
+	for i := range out.Table {
+		if loaded.Table[i].SegName != out.Table[i].SegName || loaded.Table[i].DurationType != out.Table[i].DurationType ||
+			loaded.Table[i].Amount != out.Table[i].Amount || loaded.Table[i].Duration != out.Table[i].Duration {
+			t.Errorf("loaded segment %d mismatch: got %v, expected %v", i, loaded.Table[i], out.Table[i])
+		}
+	}
+}
+
+func TestGenerateFilledOutput(t *testing.T) {
+	now := time.Date(2025, 3, 17, 8, 0, 0, 0, time.UTC)
+	// 1h paid, 2h gap left by the morning sequence and 1h gap without sequence, 1h paid
+	rules := SolverRules{
+		NewLinearFixedRule("first", timeutils.RelativeTimeSpan{From: 0, To: time.Hour}, NewAmountFromFloat(1.0), nil),
+		NewLinearFixedRule("second", timeutils.RelativeTimeSpan{From: 4 * time.Hour, To: 5 * time.Hour}, NewAmountFromFloat(1.0), nil),
+	}
+	gaps := SequenceGaps{{timeutils.RelativeTimeSpan{From: time.Hour, To: 3 * time.Hour}, "morning"}}
+	expectedGaps := []OutputGap{
+		{From: now.Add(time.Hour), To: now.Add(3 * time.Hour), Sequence: "morning"},
+		{From: now.Add(3 * time.Hour), To: now.Add(4 * time.Hour)},
+	}
+	nonpaying, banned := NonPayingDuration, BannedDuration
+
+	tests := map[string]struct {
+		fill        *DurationType
+		window      time.Duration
+		durations   []int
+		nextAllowed time.Time
+		endGap      bool
+	}{
+		"0-NotFilled":       {fill: nil, window: 5 * time.Hour, durations: []int{3600}},
+		"1-FilledNonPaying": {fill: &nonpaying, window: 5 * time.Hour, durations: []int{3600, 7200, 3600, 3600}},
+		"2-FilledBanned":    {fill: &banned, window: 5 * time.Hour, durations: []int{3600, 7200, 3600}, nextAllowed: now.Add(4 * time.Hour)},
+		// The gap after the last rule is filled up to the end of the window
+		"3-FilledUpToTheWindow": {fill: &nonpaying, window: 7 * time.Hour, durations: []int{3600, 7200, 3600, 3600, 7200}, endGap: true},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := rules.GenerateFilledOutput(now, true, testcase.fill, testcase.window, gaps, discardLogger())
+			if len(out.Table) != len(testcase.durations) {
+				t.Fatalf("expected %d segments, got %v", len(testcase.durations), out.Table)
+			}
+			for i, duration := range testcase.durations {
+				if out.Table[i].Duration != duration {
+					t.Errorf("segment %d: expected duration %d, got %d", i, duration, out.Table[i].Duration)
+				}
+				if testcase.fill != nil && (i == 1 || i == 2 || i == 4) && out.Table[i].DurationType != *testcase.fill {
+					t.Errorf("segment %d: expected type %s, got %s", i, *testcase.fill, out.Table[i].DurationType)
+				}
+			}
+			expectedGaps := expectedGaps
+			if testcase.endGap {
+				expectedGaps = append(slices.Clip(expectedGaps), OutputGap{From: now.Add(5 * time.Hour), To: now.Add(testcase.window)})
+			}
+			if len(out.Gaps) != len(expectedGaps) {
+				t.Fatalf("expected gaps %v, got %v", expectedGaps, out.Gaps)
+			}
+			for i := range expectedGaps {
+				if !out.Gaps[i].From.Equal(expectedGaps[i].From) || !out.Gaps[i].To.Equal(expectedGaps[i].To) || out.Gaps[i].Sequence != expectedGaps[i].Sequence {
+					t.Errorf("gap %d: expected %v, got %v", i, expectedGaps[i], out.Gaps[i])
+				}
+			}
+			if testcase.nextAllowed.IsZero() != (out.NextAllowed == nil) || (out.NextAllowed != nil && !out.NextAllowed.Equal(testcase.nextAllowed)) {
+				t.Errorf("expected next allowed %v, got %v", testcase.nextAllowed, out.NextAllowed)
+			}
+		})
+	}
+}
+
+func TestValidateCashStep(t *testing.T) {
+	tests := map[string]struct {
+		step  Amount
+		valid bool
+	}{
+		"0-Zero":         {step: 0, valid: false},
+		"1-Negative":     {step: mustParseAmount("-0.05"), valid: false},
+		"2-BelowMinimum": {step: mustParseAmount("0.009"), valid: false},
+		"3-MicroUnit":    {step: 1, valid: false},
+		"4-Minimum":      {step: MinCashStep, valid: true},
+		"5-Coin":         {step: mustParseAmount("0.50"), valid: true},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateCashStep(testcase.step)
+			if testcase.valid && err != nil {
+				t.Errorf("expected step %s to be valid, got %v", testcase.step, err)
+			}
+			if !testcase.valid && !errors.Is(err, ErrInvalidCashStep) {
+				t.Errorf("expected step %s to be invalid, got %v", testcase.step, err)
+			}
+		})
+	}
+}
+
+// A long linear segment is walked from step to step, it gives at most one step per second
+func TestWithCashStepLongSegment(t *testing.T) {
+	// 2 days linear at 3.00 per hour
+	const duration = 2 * 24 * 3600
+	table := OutputSegments{{Duration: duration, Amount: mustParseAmount("144"), Islinear: true, DurationType: PayingDuration}}
+
+	tests := map[string]struct {
+		step     Amount
+		expected int
+	}{
+		"0-MinimumStep": {step: MinCashStep, expected: 14400},
+		"1-MicroStep":   {step: 1, expected: duration},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := Output{Table: table}.WithCashStep(testcase.step)
+			if len(out.Table) != testcase.expected {
+				t.Fatalf("expected %d steps, got %d", testcase.expected, len(out.Table))
+			}
+			total, amount := 0, Amount(0)
+			for _, seg := range out.Table {
+				total += seg.Duration
+				amount += seg.Amount
+			}
+			if total != duration || amount != table[0].Amount {
+				t.Errorf("expected %ds for %s, got %ds for %s", duration, table[0].Amount, total, amount)
+			}
+		})
+	}
+}
+
+func TestWithCashStep(t *testing.T) {
+	// 30min fixed 0.50, 1h linear 1.00, 10h night non-paying, 2h linear 3.00, paid by steps of 0.20
+	step := mustParseAmount("0.20")
+	out := Output{
+		Now: time.Date(2025, 3, 17, 18, 0, 0, 0, time.UTC),
+		Table: OutputSegments{
+			{Duration: 1800, Amount: mustParseAmount("0.50"), Islinear: false, DurationType: PayingDuration},
+			{Duration: 3600, Amount: mustParseAmount("1.00"), Islinear: true, DurationType: PayingDuration},
+			{Duration: 36000, Amount: 0, Islinear: false, DurationType: NonPayingDuration},
+			{Duration: 7200, Amount: mustParseAmount("3.00"), Islinear: true, DurationType: PayingDuration},
+		},
+	}.WithCashStep(step)
+
+	if out.CashStep != step {
+		t.Errorf("expected cash step %s, got %s", step, out.CashStep)
+	}
+	total := 0
+	for i, seg := range out.Table {
+		if seg.Islinear || seg.Amount%step != 0 {
+			t.Errorf("segment %d: expected a step multiple of %s, got %v", i, step, seg)
+		}
+		total += seg.Duration
+	}
+	if total != 1800+3600+36000+7200 {
+		t.Errorf("expected the duration of the table unchanged, got %d", total)
+	}
+
+	amounts := map[string]struct {
+		duration time.Duration
+		expected Amount
+	}{
+		"0-FixedRoundedUp":          {duration: 30 * time.Minute, expected: mustParseAmount("0.60")},
+		"1-LinearPaidByRounding":    {duration: 36 * time.Minute, expected: mustParseAmount("0.60")},
+		"2-NextStep":                {duration: 36*time.Minute + time.Second, expected: mustParseAmount("0.80")},
+		"3-EndOfLinear":             {duration: 90 * time.Minute, expected: mustParseAmount("1.60")},
+		"4-AfterNonPaying":          {duration: 11*time.Hour + 34*time.Minute, expected: mustParseAmount("1.60")},
+		"5-StepAfterNonPaying":      {duration: 11*time.Hour + 34*time.Minute + time.Second, expected: mustParseAmount("1.80")},
+		"6-WholeTableRoundedUp":     {duration: 13*time.Hour + 30*time.Minute, expected: mustParseAmount("4.60")},
+		"7-BeyondTheTableNotBought": {duration: 14 * time.Hour, expected: 0},
+	}
+	for name, testcase := range amounts {
+		t.Run(name, func(t *testing.T) {
+			if amount := out.AmountForDuration(testcase.duration); amount != testcase.expected {
+				t.Errorf("AmountForDuration(%v) expected %s, got %s", testcase.duration, testcase.expected, amount)
+			}
+		})
+	}
+
+	durations := map[string]struct {
+		amount   Amount
+		expected time.Duration
+	}{
+		"0-LessThanFirstStep": {amount: mustParseAmount("0.50"), expected: 0},
+		"1-FirstStep":         {amount: mustParseAmount("0.60"), expected: 36 * time.Minute},
+		"2-BetweenSteps":      {amount: mustParseAmount("0.79"), expected: 36 * time.Minute},
+		"3-NextStep":          {amount: mustParseAmount("0.80"), expected: 48 * time.Minute},
+		"4-NonPayingIncluded": {amount: mustParseAmount("1.60"), expected: 11*time.Hour + 34*time.Minute},
+		"5-WholeTable":        {amount: mustParseAmount("4.60"), expected: 13*time.Hour + 30*time.Minute},
+	}
+	for name, testcase := range durations {
+		t.Run(name, func(t *testing.T) {
+			if duration := out.DurationForAmount(testcase.amount); duration != testcase.expected {
+				t.Errorf("DurationForAmount(%s) expected %v, got %v", testcase.amount, testcase.expected, duration)
+			}
+		})
+	}
+
+	points := out.PurchasePoints()
+	if len(points) != 21 {
+		t.Fatalf("expected 21 purchase points, got %d: %v", len(points), points)
+	}
+	for i, point := range points {
+		if expected := step * Amount(i+3); point.Amount != expected {
+			t.Errorf("point %d: expected amount %s, got %s", i, expected, point.Amount)
+		}
+		if end := out.EndDateForAmount(point.Amount); !point.End.Equal(end) {
+			t.Errorf("point %d: expected end %v for %s, got %v", i, end, point.Amount, point.End)
+		}
+	}
+}
+
+// The free time at the beginning of the table is a point of amount 0, a linear segment gives no point
+func TestPurchasePoints(t *testing.T) {
+	now := time.Date(2025, 3, 17, 18, 0, 0, 0, time.UTC)
+	out := Output{
+		Now: now,
+		Table: OutputSegments{
+			{Duration: 900, Amount: 0, Islinear: false, DurationType: FreeDuration},
+			{Duration: 1800, Amount: mustParseAmount("0.50"), Islinear: false, DurationType: PayingDuration},
+			{Duration: 3600, Amount: mustParseAmount("1.00"), Islinear: true, DurationType: PayingDuration},
+			{Duration: 1800, Amount: mustParseAmount("0.50"), Islinear: false, DurationType: PayingDuration},
+		},
+	}
+	expected := []PurchasePoint{
+		{Amount: 0, End: now.Add(15 * time.Minute)},
+		{Amount: mustParseAmount("0.50"), End: now.Add(45 * time.Minute)},
+		{Amount: mustParseAmount("2.00"), End: now.Add(2*time.Hour + 15*time.Minute)},
+	}
+	points := out.PurchasePoints()
+	if len(points) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, points)
+	}
+	for i := range expected {
+		if points[i].Amount != expected[i].Amount || !points[i].End.Equal(expected[i].End) {
+			t.Errorf("point %d: expected %v, got %v", i, expected[i], points[i])
+		}
+	}
+}
@@ -5,25 +5,59 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/goccy/go-yaml/ast"
+	"github.com/iem-rd/quote-engine/timeutils"
 )
 
 type ParserTariffRoot struct {
 	Version   string   `yaml:"version"`
 	NonPaying ast.Node `yaml:"nonpaying"`
+	Banned    ast.Node `yaml:"banned"`
 	Quotas    ast.Node `yaml:"quotas"`
 	Sequences ast.Node `yaml:"sequences"`
 	Config    ast.Node `yaml:"config"`
+	Versions  ast.Node `yaml:"versions"`
 }
 
-func decoderOptions() []yaml.DecodeOption {
+// ParserTariffVersion holds the sections of a tariff version, the ones missing in a version are inherited
+// from the previous version
+type ParserTariffVersion struct {
+	Effective string   `yaml:"effective"`
+	NonPaying ast.Node `yaml:"nonpaying"`
+	Banned    ast.Node `yaml:"banned"`
+	Quotas    ast.Node `yaml:"quotas"`
+	Sequences ast.Node `yaml:"sequences"`
+}
+
+// NodeError is an error related to a specific node of the tariff definition, it allows to locate the
+// error in the YAML source when the underlying error doesn't carry any position
+type NodeError struct {
+	Node ast.Node
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// decoderOptions returns the options to decode the tariff sections, the dates are parsed in the given location
+func decoderOptions(loc *time.Location) []yaml.DecodeOption {
 	return []yaml.DecodeOption{
 		yaml.Strict(),
 		yaml.CustomUnmarshaler(unmarshalTimeDuration),
-		yaml.CustomUnmarshaler(unmarshalRecurrentDate),
-		yaml.CustomUnmarshaler(unmarshalQuota),
+		yaml.CustomUnmarshaler(func(rec *timeutils.RecurrentDate, data []byte) error {
+			return unmarshalRecurrentDate(rec, data, loc)
+		}),
+		yaml.CustomUnmarshaler(func(quota *Quota, data []byte) error {
+			return unmarshalQuota(quota, data, loc)
+		}),
 	}
 }
 
@@ -57,43 +91,120 @@ func ParseTariffDefinition(data []byte) (TariffDefinition, error) {
 
 	ctx := context.Background()
 
-	// Decode the config section
+	// Decode the config section, the missing settings keep their default value
+	tariff.Config = DefaultConfig()
 	if desc.Config != nil {
-		err = nodeToValueContext(ctx, desc.Config, &tariff.Config, decoderOptions()...)
+		err = nodeToValueContext(ctx, desc.Config, &tariff.Config, decoderOptions(time.Local)...)
 		if err != nil {
-			return tariff, fmt.Errorf("failed to parse config section: %w", err)
+			return tariff, &NodeError{desc.Config, fmt.Errorf("failed to parse config section: %w", err)}
 		}
-	} else {
-		tariff.Config = DefaultConfig()
 	}
+	if fill := tariff.Config.FillGaps; fill != nil && *fill == PayingDuration {
+		return tariff, &NodeError{desc.Config, fmt.Errorf("invalid fillgaps: gaps cannot be filled with paying segments")}
+	}
+	tariff.Config.location, err = LoadLocation(tariff.Config.Timezone)
+	if err != nil {
+		return tariff, &NodeError{desc.Config, fmt.Errorf("invalid timezone: %w", err)}
+	}
+	loc := tariff.Config.Location()
+
+	// Decode the sections of the initial version
+	if desc.Sequences == nil {
+		return tariff, fmt.Errorf("sequences section is missing")
+	}
+	sections := ParserTariffVersion{NonPaying: desc.NonPaying, Banned: desc.Banned, Quotas: desc.Quotas, Sequences: desc.Sequences}
+	initial, err := parseTariffVersion(sections, loc)
+	if err != nil {
+		return tariff, err
+	}
+	tariff.Quotas, tariff.NonPaying, tariff.Banned, tariff.Sequences = initial.Quotas, initial.NonPaying, initial.Banned, initial.Sequences
+
+	// Decode the following versions, each one inherits the sections it doesn't redefine from the previous one
+	if desc.Versions != nil && sequenceItems(desc.Versions) == nil {
+		return tariff, &NodeError{desc.Versions, fmt.Errorf("versions section must be a list")}
+	}
+	for _, item := range sequenceItems(desc.Versions) {
+		var v ParserTariffVersion
+		err = nodeToValueContext(ctx, item, &v, yaml.Strict())
+		if err != nil {
+			return tariff, &NodeError{item, fmt.Errorf("failed to parse version: %w", err)}
+		}
+		if v.NonPaying != nil {
+			sections.NonPaying = v.NonPaying
+		}
+		if v.Banned != nil {
+			sections.Banned = v.Banned
+		}
+		if v.Quotas != nil {
+			sections.Quotas = v.Quotas
+		}
+		if v.Sequences != nil {
+			sections.Sequences = v.Sequences
+		}
+
+		version, err := parseTariffVersion(sections, loc)
+		if err != nil {
+			return tariff, err
+		}
+		version.Effective, err = timeutils.ParseDate(v.Effective, loc)
+		if err != nil {
+			return tariff, &NodeError{item, fmt.Errorf("invalid effective date: %w", err)}
+		}
+		if n := len(tariff.Versions); n > 0 && !version.Effective.After(tariff.Versions[n-1].Effective) {
+			return tariff, &NodeError{item, fmt.Errorf("versions must be sorted by effective date: %s", v.Effective)}
+		}
+		tariff.Versions = append(tariff.Versions, version)
+	}
+
+	// The tariff quota must exist in all the versions
+	if name := tariff.Config.Quota; name != "" {
+		for _, version := range tariff.versions() {
+			if _, exists := version.Quotas[name]; !exists {
+				return tariff, &NodeError{desc.Config, fmt.Errorf("unknown quota: %s", name)}
+			}
+		}
+	}
+
+	return tariff, nil
+}
+
+// parseTariffVersion decodes the sections of a tariff version, the quotas are decoded first as the other
+// sections refer to them
+func parseTariffVersion(sections ParserTariffVersion, loc *time.Location) (TariffVersion, error) {
+	var version TariffVersion
+	ctx := context.Background()
 
 	// Decode the nonpaying section
-	if desc.NonPaying != nil {
-		err = nodeToValueContext(ctx, desc.NonPaying, &tariff.NonPaying, decoderOptions()...)
+	if sections.NonPaying != nil {
+		err := nodeToValueContext(ctx, sections.NonPaying, &version.NonPaying, decoderOptions(loc)...)
 		if err != nil {
-			return tariff, fmt.Errorf("failed to parse nonpaying section: %w", err)
+			return version, &NodeError{sections.NonPaying, fmt.Errorf("failed to parse nonpaying section: %w", err)}
+		}
+	}
+
+	// Decode the banned section
+	if sections.Banned != nil {
+		err := nodeToValueContext(ctx, sections.Banned, &version.Banned, decoderOptions(loc)...)
+		if err != nil {
+			return version, &NodeError{sections.Banned, fmt.Errorf("failed to parse banned section: %w", err)}
 		}
 	}
 
 	// Decode the quotas section
-	if desc.Quotas != nil {
-		err = nodeToValueContext(ctx, desc.Quotas, &tariff.Quotas, decoderOptions()...)
+	if sections.Quotas != nil {
+		err := nodeToValueContext(ctx, sections.Quotas, &version.Quotas, decoderOptions(loc)...)
 		if err != nil {
-			return tariff, fmt.Errorf("failed to parse quotas section: %w", err)
+			return version, &NodeError{sections.Quotas, fmt.Errorf("failed to parse quotas section: %w", err)}
 		}
 	}
-	ctx = ContextSetQuota(ctx, tariff.Quotas)
+	ctx = ContextSetQuota(ctx, version.Quotas)
 
 	// Decode the sequences section
-	if desc.Sequences == nil {
-		return tariff, fmt.Errorf("sequences section is missing")
-	}
-	err = nodeToValueContext(ctx, desc.Sequences, &tariff.Sequences, decoderOptions()...)
+	err := nodeToValueContext(ctx, sections.Sequences, &version.Sequences, decoderOptions(loc)...)
 	if err != nil {
-		return tariff, fmt.Errorf("failed to parse sequences section: %w", err)
+		return version, &NodeError{sections.Sequences, fmt.Errorf("failed to parse sequences section: %w", err)}
 	}
-
-	return tariff, nil
+	return version, nil
 }
 
 func ParseTariffDefinitionFile(filename string) (TariffDefinition, error) {
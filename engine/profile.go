@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// CustomerProfile describes the customer asking for a quote, it selects the sequences and rules
+// applying to this customer (see Condition)
+type CustomerProfile struct {
+	TariffCode string   `json:"tariffCode" yaml:"tariff"` // Identifier of the tariff
+	LayerCode  string   `json:"layerCode" yaml:"layer"`   // Zone code
+	Flags      []string `json:"flags" yaml:"flags"`       // Customer flags (such as PMR, etc.)
+}
+
+// Condition restricts a sequence or a rule to the customers matching all its patterns, the patterns are
+// case insensitive globs and an empty pattern matches any customer
+type Condition struct {
+	TariffCodePattern string `yaml:"tariff"`
+	LayerCodePattern  string `yaml:"layer"`
+	FlagsPattern      string `yaml:"flags"`
+}
+
+// Stringer for Condition, print the tariff, layer and flags patterns
+func (c Condition) String() string {
+	return fmt.Sprintf("(%s, %s, %s)", c.TariffCodePattern, c.LayerCodePattern, c.FlagsPattern)
+}
+
+// Match checks if the customer profile matches the condition, a nil condition matches any customer
+func (c *Condition) Match(profile CustomerProfile) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	// Reuse the assigned rights matching, the customer flags are matched like the rights flags
+	right := AssignedRight{TariffCode: profile.TariffCode, LayerCode: profile.LayerCode, Flags: profile.Flags}
+	matchers := []struct {
+		pattern string
+		match   func(string) (bool, error)
+	}{
+		{c.TariffCodePattern, right.MatchTariffCode},
+		{c.LayerCodePattern, right.MatchLayerCode},
+		{c.FlagsPattern, right.MatchFlags},
+	}
+	for _, m := range matchers {
+		if m.pattern == "" {
+			continue
+		}
+		match, err := m.match(m.pattern)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (c *Condition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Condition
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	// Check the patterns now rather than at computation time
+	for _, pattern := range []string{c.TariffCodePattern, c.LayerCodePattern, c.FlagsPattern} {
+		if _, err := globMatch(pattern, ""); err != nil {
+			return fmt.Errorf("invalid condition pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// profileRule is a rule restricted to some customers by a condition
+type profileRule interface {
+	Applies(profile CustomerProfile) (bool, error)
+	String() string
+}
+
+// filterForProfile returns the rules applying to the customer profile
+func filterForProfile[S ~[]T, T profileRule](rules S, profile CustomerProfile) (S, error) {
+	out := make(S, 0, len(rules))
+	for _, rule := range rules {
+		match, err := rule.Applies(profile)
+		if err != nil {
+			return nil, fmt.Errorf("%w for rule %s: %w", ErrProfileMatching, rule, err)
+		}
+		if match {
+			out = append(out, rule)
+		}
+	}
+	return out, nil
+}
+
+// ForProfile returns the rules applying to the customer profile
+func (rules SolvableRules) ForProfile(profile CustomerProfile) (SolvableRules, error) {
+	return filterForProfile(rules, profile)
+}
+
+// ForProfile returns the nonpaying rules applying to the customer profile
+func (rules AbsoluteNonPayingRules) ForProfile(profile CustomerProfile) (AbsoluteNonPayingRules, error) {
+	return filterForProfile(rules, profile)
+}
+
+// ForProfile returns the banned rules applying to the customer profile
+func (rules AbsoluteBannedRules) ForProfile(profile CustomerProfile) (AbsoluteBannedRules, error) {
+	return filterForProfile(rules, profile)
+}
+
+// ForProfile returns a copy of the inventory with only the sequences and rules applying to the customer profile
+func (inventory TariffSequenceInventory) ForProfile(profile CustomerProfile) (TariffSequenceInventory, error) {
+	out := make(TariffSequenceInventory, 0, len(inventory))
+	for _, seq := range inventory {
+		match, err := seq.When.Match(profile)
+		if err != nil {
+			return nil, fmt.Errorf("%w for sequence %s: %w", ErrProfileMatching, seq.Name, err)
+		}
+		if !match {
+			continue
+		}
+		seq.Rules, err = seq.Rules.ForProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, seq)
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConditionMatch(t *testing.T) {
+	tests := map[string]struct {
+		condition *Condition
+		profile   CustomerProfile
+		expected  bool
+		err       error
+	}{
+		"0-NilCondition":      {condition: nil, profile: CustomerProfile{}, expected: true},
+		"1-EmptyCondition":    {condition: &Condition{}, profile: CustomerProfile{Flags: []string{"pmr"}}, expected: true},
+		"2-MatchingFlag":      {condition: &Condition{FlagsPattern: "pmr"}, profile: CustomerProfile{Flags: []string{"pro", "PMR"}}, expected: true},
+		"3-NoFlag":            {condition: &Condition{FlagsPattern: "pmr"}, profile: CustomerProfile{}, expected: false},
+		"4-MatchingLayer":     {condition: &Condition{LayerCodePattern: "Z*"}, profile: CustomerProfile{LayerCode: "ZONE_A"}, expected: true},
+		"5-OtherLayer":        {condition: &Condition{LayerCodePattern: "Z*"}, profile: CustomerProfile{LayerCode: "A1"}, expected: false},
+		"6-AllPatterns":       {condition: &Condition{TariffCodePattern: "t?", LayerCodePattern: "Z*", FlagsPattern: "pro"}, profile: CustomerProfile{TariffCode: "t1", LayerCode: "Z1", Flags: []string{"pro"}}, expected: true},
+		"7-OnePatternFailing": {condition: &Condition{TariffCodePattern: "t?", LayerCodePattern: "Z*", FlagsPattern: "pro"}, profile: CustomerProfile{TariffCode: "t10", LayerCode: "Z1", Flags: []string{"pro"}}, expected: false},
+		"8-InvalidPattern":    {condition: &Condition{LayerCodePattern: "[Z"}, profile: CustomerProfile{LayerCode: "Z1"}, err: filepath.ErrBadPattern},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			match, err := testcase.condition.Match(testcase.profile)
+			if !errors.Is(err, testcase.err) {
+				t.Fatalf("expected error %v, got %v", testcase.err, err)
+			}
+			if match != testcase.expected {
+				t.Errorf("expected match %v, got %v", testcase.expected, match)
+			}
+		})
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := map[string]struct {
+		tariff   string
+		expected string
+	}{
+		"0-InvalidPattern": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "pmr"
+      when:
+        flags: "[pmr"
+      hourlyrate: 0
+      duration: 2h
+`,
+			expected: `invalid condition pattern "[pmr": syntax error in pattern`,
+		},
+		"1-LastSequenceCondition": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  when:
+    flags: "pmr"
+  rules: []
+`,
+			expected: "last sequence must not have a when condition",
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTariffDefinition([]byte(testcase.tariff))
+			if err == nil || !strings.Contains(err.Error(), testcase.expected) {
+				t.Errorf("expected error %q, got %v", testcase.expected, err)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,6 +20,7 @@ type DurationDetail struct {
 	Type     DurationType  // Type of the duration (Free, Paid, etc.)
 	Start    time.Time     // Start date of the duration (used for advanced quota types)
 	Duration time.Duration // Duration of the parking
+	Amount   Amount        // Amount paid for the duration (used for amount quotas)
 }
 
 // AssignedRight represents the parking assigned rights (a ticket)
@@ -36,6 +40,7 @@ func (dd *DurationDetail) UnmarshalJSON(data []byte) error {
 		Type     DurationType `json:"type"`
 		Start    time.Time    `json:"start"`
 		Duration int          `json:"duration"` //Duration as seconds
+		Amount   Amount       `json:"amount"`
 	}
 	err := yaml.Unmarshal(data, &temp)
 	if err != nil {
@@ -44,6 +49,7 @@ func (dd *DurationDetail) UnmarshalJSON(data []byte) error {
 	dd.Type = temp.Type
 	dd.Start = temp.Start
 	dd.Duration = time.Duration(temp.Duration) * time.Second
+	dd.Amount = temp.Amount
 	return nil
 }
 
@@ -124,10 +130,16 @@ func (m MatchingRules) String() string {
 // Quota represents a quota to be used to limit the parking assigned rights
 type Quota interface {
 	GetName() string
-	Update(now time.Time, history AssignedRights) error
+	Update(now time.Time, history AssignedRights, logger *slog.Logger) error
 	IsExausted() bool
 	UseDuration(duration time.Duration) time.Duration
 	GetRightExpiryDate(now time.Time) (time.Time, error)
+	ToOutput(now time.Time) (OutputQuota, error)
+	MatchProfile(profile CustomerProfile, logger *slog.Logger) (bool, error)
+	Clone() Quota
+	// ContinueFrom takes over the usage of the quota of the same name of the previous tariff version, the quota
+	// keeps its own usage if the previous one is of another kind
+	ContinueFrom(previous Quota)
 	String() string
 }
 
@@ -161,7 +173,7 @@ func globMatch(pattern, name string) (bool, error) {
 }
 
 // Filter filters the history of assigned rights based on the matching rules and calls the matchHandler for each matching detail
-func (q AbstractQuota) Filter(from time.Time, history AssignedRights, matchAssignedRightHandler func(right AssignedRight),
+func (q AbstractQuota) Filter(from time.Time, history AssignedRights, logger *slog.Logger, matchAssignedRightHandler func(right AssignedRight),
 	matchDurationDetailsHandler func(detail DurationDetail)) error {
 	rules := q.MatchingRules
 	if len(rules) == 0 {
@@ -170,7 +182,7 @@ func (q AbstractQuota) Filter(from time.Time, history AssignedRights, matchAssig
 	// Iterate over all matching rules of the quota
 	for _, rule := range rules {
 
-		fmt.Println(" >> Matching rule", rule.String(), "from", from)
+		logger.Debug("matching rule", "quota", q.Name, "rule", rule, "from", from)
 
 		// Iterate over all assigned rights in the history
 		for i, right := range history {
@@ -208,7 +220,7 @@ func (q AbstractQuota) Filter(from time.Time, history AssignedRights, matchAssig
 			// Check if all the matching rules match and if set, call the Assigned Right callback
 			match := matchTariffCode && matchLayerCode && matchFlags
 			if match {
-				fmt.Println("   >> Assigned right", i, "starting on", right.StartDate, "matches", rule.String())
+				logger.Debug("assigned right matches", "quota", q.Name, "index", i, "start", right.StartDate, "rule", rule)
 			}
 			if match && matchAssignedRightHandler != nil {
 				matchAssignedRightHandler(right)
@@ -227,6 +239,7 @@ func (q AbstractQuota) Filter(from time.Time, history AssignedRights, matchAssig
 					if match {
 						reftime := SelectReferenceTime(rule, detail, right)
 						if !reftime.IsZero() && timeutils.TimeAfterOrEqual(reftime, from) {
+							detail.Start = reftime
 							matchDurationDetailsHandler(detail)
 						}
 					}
@@ -237,6 +250,15 @@ func (q AbstractQuota) Filter(from time.Time, history AssignedRights, matchAssig
 	return nil
 }
 
+// MatchProfile checks if the quota applies to the customer profile, the profile is matched like a parking right
+// of this customer
+func (q AbstractQuota) MatchProfile(profile CustomerProfile, logger *slog.Logger) (bool, error) {
+	match := false
+	right := AssignedRight{TariffCode: profile.TariffCode, LayerCode: profile.LayerCode, Flags: profile.Flags}
+	err := q.Filter(time.Time{}, AssignedRights{right}, logger, func(AssignedRight) { match = true }, nil)
+	return match, err
+}
+
 func (q AbstractQuota) PeriodStart(now time.Time) (time.Time, error) {
 	return q.PeriodicityRule.Prev(now)
 }
@@ -245,6 +267,20 @@ func (q AbstractQuota) GetRightExpiryDate(now time.Time) (time.Time, error) {
 	return q.PeriodicityRule.Next(now)
 }
 
+// newOutputQuota builds the report of the quota state before the quote, the usage of the table is filled by the
+// inventory from the output segments
+func newOutputQuota(quota Quota, now time.Time, quotaType string, allowance, before float64) (OutputQuota, error) {
+	out := OutputQuota{Name: quota.GetName(), Type: quotaType, Allowance: allowance, UsedBefore: before}
+	reset, err := quota.GetRightExpiryDate(now)
+	if err != nil {
+		return OutputQuota{}, fmt.Errorf("%w for quota %s reset date: %w", ErrRecurrentRule, quota.GetName(), err)
+	}
+	if reset.After(now) {
+		out.NextReset = &reset
+	}
+	return out, nil
+}
+
 // Stringer for AbstractQuota, print the matching rule and periodicity rule
 func (q AbstractQuota) String() string {
 	return fmt.Sprintf("PeriodicityRule: %v, MatchingRules: %v", q.PeriodicityRule, q.MatchingRules)
@@ -254,7 +290,11 @@ func (q AbstractQuota) String() string {
 type DurationQuota struct {
 	AbstractQuota `yaml:",inline"`
 	Allowance     time.Duration `yaml:"allowance"`
+	Rolling       time.Duration `yaml:"rolling"` // Rolling window replacing the periodicity, if set
 	used          time.Duration
+	before        time.Duration                // Duration used before the quote
+	now           time.Time                    // Time of the last update
+	usage         []timeutils.RelativeTimeSpan // Rolling quota usage, sorted and relative to the last update
 }
 
 func NewDurationQuota(name string, allowance time.Duration, period timeutils.RecurrentDate, rules []MatchingRule) *DurationQuota {
@@ -273,22 +313,63 @@ func NewDurationQuota(name string, allowance time.Duration, period timeutils.Rec
 }
 
 // Update updates the quota based on the history of assigned rights
-func (q *DurationQuota) Update(now time.Time, history AssignedRights) error {
-	fmt.Println("Update duration quota", q.Name)
+func (q *DurationQuota) Update(now time.Time, history AssignedRights, logger *slog.Logger) error {
+	if q.Rolling > 0 {
+		return q.updateRolling(now, history, logger)
+	}
 	total := time.Duration(0)
 	// Compute the start period of quota calculation
 	start, err := q.PeriodStart(now)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrRecurrentRule, err)
 	}
 	// Compute the total duration of matching assigned rights
-	err = q.Filter(start, history, nil, func(detail DurationDetail) {
+	err = q.Filter(start, history, logger, nil, func(detail DurationDetail) {
 		total += detail.Duration
-		fmt.Println("   >> Duration detail", detail.Type, "duration", detail.Duration, "from", detail.Start)
+		logger.Debug("duration detail matches", "quota", q.Name, "type", detail.Type, "duration", detail.Duration, "start", detail.Start)
 	})
-	q.used = total
-	fmt.Println("Duration quota", q.Name, "used", q.used, "out of", q.Allowance)
-	return err
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrQuotaMatching, err)
+	}
+	q.used, q.before = total, total
+	logger.Debug("duration quota updated", "quota", q.Name, "used", q.used, "allowance", q.Allowance)
+	return nil
+}
+
+// updateRolling keeps the parts of the matching rights inside the rolling window ending at now, the rights
+// are counted up to now
+func (q *DurationQuota) updateRolling(now time.Time, history AssignedRights, logger *slog.Logger) error {
+	q.now = now
+	q.usage = nil
+	err := q.Filter(time.Time{}, history, logger, nil, func(detail DurationDetail) {
+		span := timeutils.RelativeTimeSpan{From: max(detail.Start.Sub(now), -q.Rolling), To: min(detail.Start.Add(detail.Duration).Sub(now), 0)}
+		if span.From < span.To {
+			logger.Debug("duration detail matches", "quota", q.Name, "type", detail.Type, "duration", detail.Duration, "start", detail.Start)
+			q.usage = addTimeSpan(q.usage, span)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrQuotaMatching, err)
+	}
+	q.used = usedBetween(q.usage, -q.Rolling, 0)
+	q.before = q.used
+	logger.Debug("rolling duration quota updated", "quota", q.Name, "used", q.used, "allowance", q.Allowance, "rolling", q.Rolling)
+	return nil
+}
+
+// Clone returns a copy of the quota with its own usage state
+func (q *DurationQuota) Clone() Quota {
+	clone := *q
+	clone.usage = slices.Clone(q.usage)
+	return &clone
+}
+
+// ContinueFrom takes over the usage of a duration quota of the same kind, periodic or rolling
+func (q *DurationQuota) ContinueFrom(previous Quota) {
+	if prev, ok := previous.(*DurationQuota); ok && (prev.Rolling > 0) == (q.Rolling > 0) {
+		q.used, q.before, q.now = prev.used, prev.before, prev.now
+		q.usage = slices.Clone(prev.usage)
+	}
 }
 
 func (q *DurationQuota) Available() time.Duration {
@@ -308,6 +389,13 @@ func (q *DurationQuota) IsExausted() bool {
 }
 
 func (q *DurationQuota) UseDuration(duration time.Duration) time.Duration {
+	if q.Rolling > 0 {
+		granted := time.Duration(0)
+		for _, span := range q.UseTimeSpan(timeutils.RelativeTimeSpan{From: 0, To: duration}, true) {
+			granted += span.Duration()
+		}
+		return granted
+	}
 	if duration > q.Available() {
 		duration = q.Available()
 	}
@@ -315,16 +403,137 @@ func (q *DurationQuota) UseDuration(duration time.Duration) time.Duration {
 	return duration
 }
 
+// UseTimeSpan uses the quota over the timespan, relative to the last update, and returns the granted parts of the
+// timespan. A periodic quota grants its available duration from the start of the timespan. A rolling quota grants
+// the time where less than the allowance is used over the rolling window ending at this time, so the allowance
+// freed by the old rights leaving the window is granted later in the timespan. If contiguous is set, only the
+// part starting at the beginning of the timespan is granted.
+func (q *DurationQuota) UseTimeSpan(span timeutils.RelativeTimeSpan, contiguous bool) []timeutils.RelativeTimeSpan {
+	if q.Rolling <= 0 {
+		granted := q.UseDuration(span.Duration())
+		if granted == 0 {
+			return nil
+		}
+		return []timeutils.RelativeTimeSpan{{From: span.From, To: span.From + granted}}
+	}
+
+	var granted []timeutils.RelativeTimeSpan
+	for t := span.From; t < span.To; {
+		// Up to the next bound of a used part entering or leaving the window, the usage changes linearly
+		next := span.To
+		for _, u := range q.usage {
+			for _, bound := range []time.Duration{u.From, u.To, u.From + q.Rolling, u.To + q.Rolling} {
+				if bound > t && bound < next {
+					next = bound
+				}
+			}
+		}
+		used := usedBetween(q.usage, t-q.Rolling, t)
+		leaving := usedBetween(q.usage, t-q.Rolling, next-q.Rolling) == next-t
+
+		end := t
+		switch {
+		case usedBetween(q.usage, t, next) > 0:
+			// Already used, by another rule
+		case used < q.Allowance && leaving:
+			end = next
+		case used < q.Allowance:
+			end = min(next, t+q.Allowance-used)
+		case leaving && used == q.Allowance:
+			// Use the allowance as it is freed
+			end = next
+		case leaving:
+			// Wait for the usage to go back to the allowance
+			next = min(next, t+used-q.Allowance)
+		}
+		if end > t {
+			q.usage = addTimeSpan(q.usage, timeutils.RelativeTimeSpan{From: t, To: end})
+			granted = addTimeSpan(granted, timeutils.RelativeTimeSpan{From: t, To: end})
+			next = end
+		} else if contiguous {
+			break
+		}
+		t = next
+	}
+	q.used = usedBetween(q.usage, -q.Rolling, 0)
+	return granted
+}
+
+// GetRightExpiryDate returns the next period start, or for a rolling quota the time when the whole allowance
+// is available again
+func (q *DurationQuota) GetRightExpiryDate(now time.Time) (time.Time, error) {
+	if q.Rolling <= 0 {
+		return q.AbstractQuota.GetRightExpiryDate(now)
+	}
+	if len(q.usage) == 0 {
+		return now, nil
+	}
+	if expiry := q.now.Add(q.usage[len(q.usage)-1].To + q.Rolling); expiry.After(now) {
+		return expiry, nil
+	}
+	return now, nil
+}
+
 // Stringer for DurationQuota, print the name and the used/allowed values
 func (q DurationQuota) String() string {
+	if q.Rolling > 0 {
+		return fmt.Sprintf("DurationQuota(%s): Usage %s/%s rolling %s %v", q.Name, q.used, q.Allowance, q.Rolling, q.AbstractQuota)
+	}
 	return fmt.Sprintf("DurationQuota(%s): Usage %s/%s %v", q.Name, q.used, q.Allowance, q.AbstractQuota)
 }
 
+// remainingAfter returns the allowance of a rolling quota left at the end of the last timespan used by the table,
+// relative to now, the usage which has left the rolling window by then is not counted
+func (q *DurationQuota) remainingAfter(now time.Time, spans []timeutils.RelativeTimeSpan) time.Duration {
+	usage := slices.Clone(q.usage)
+	shift := now.Sub(q.now)
+	end := shift
+	for _, span := range spans {
+		usage = addTimeSpan(usage, timeutils.RelativeTimeSpan{From: span.From + shift, To: span.To + shift})
+		end = max(end, span.To+shift)
+	}
+	return max(q.Allowance-usedBetween(usage, end-q.Rolling, end), 0)
+}
+
+// ToOutput reports the duration used before the quote, in seconds
+func (q *DurationQuota) ToOutput(now time.Time) (OutputQuota, error) {
+	return newOutputQuota(q, now, "duration", q.Allowance.Seconds(), q.before.Seconds())
+}
+
+// usedBetween returns the duration of the sorted usage inside [from, to)
+func usedBetween(usage []timeutils.RelativeTimeSpan, from, to time.Duration) time.Duration {
+	total := time.Duration(0)
+	for _, u := range usage {
+		if overlap := min(u.To, to) - max(u.From, from); overlap > 0 {
+			total += overlap
+		}
+	}
+	return total
+}
+
+// addTimeSpan inserts the timespan in the sorted usage, merging the overlapping and adjacent timespans
+func addTimeSpan(usage []timeutils.RelativeTimeSpan, span timeutils.RelativeTimeSpan) []timeutils.RelativeTimeSpan {
+	out := make([]timeutils.RelativeTimeSpan, 0, len(usage)+1)
+	for _, u := range usage {
+		switch {
+		case u.To < span.From:
+			out = append(out, u)
+		case u.From > span.To:
+			out = append(out, span)
+			span = u
+		default:
+			span = timeutils.RelativeTimeSpan{From: min(u.From, span.From), To: max(u.To, span.To)}
+		}
+	}
+	return append(out, span)
+}
+
 // CounterQuota represents a quota based on the number of parking assigned rights
 type CounterQuota struct {
 	AbstractQuota `yaml:",inline"`
 	Allowance     int `yaml:"allowance"`
 	used          int
+	before        int // Parking rights counted before the quote
 }
 
 func NewCounterQuota(name string, allowance int, period timeutils.RecurrentDate, rules []MatchingRule) *CounterQuota {
@@ -343,21 +552,36 @@ func NewCounterQuota(name string, allowance int, period timeutils.RecurrentDate,
 }
 
 // Update updates the quota based on the history of assigned rights
-func (q *CounterQuota) Update(now time.Time, history AssignedRights) error {
-	fmt.Println("Update counter quota", q.Name)
+func (q *CounterQuota) Update(now time.Time, history AssignedRights, logger *slog.Logger) error {
 	counter := 0
 	// Compute the start period of quota calculation
 	start, err := q.PeriodStart(now)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrRecurrentRule, err)
 	}
 	// Compute the number of matching assigned rights
-	err = q.Filter(start, history, func(detail AssignedRight) {
+	err = q.Filter(start, history, logger, func(detail AssignedRight) {
 		counter++
 	}, nil)
-	q.used = counter
-	fmt.Println("Counter quota", q.Name, "used", q.used, "out of", q.Allowance)
-	return err
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrQuotaMatching, err)
+	}
+	q.used, q.before = counter, counter
+	logger.Debug("counter quota updated", "quota", q.Name, "used", q.used, "allowance", q.Allowance)
+	return nil
+}
+
+// Clone returns a copy of the quota with its own usage state
+func (q *CounterQuota) Clone() Quota {
+	clone := *q
+	return &clone
+}
+
+// ContinueFrom takes over the usage of a counter quota
+func (q *CounterQuota) ContinueFrom(previous Quota) {
+	if prev, ok := previous.(*CounterQuota); ok {
+		q.used, q.before = prev.used, prev.before
+	}
 }
 
 func (q *CounterQuota) Available() int {
@@ -384,39 +608,211 @@ func (q *CounterQuota) UseDuration(duration time.Duration) time.Duration {
 	return duration
 }
 
+// ToOutput reports the parking rights counted before the quote
+func (q *CounterQuota) ToOutput(now time.Time) (OutputQuota, error) {
+	return newOutputQuota(q, now, "counter", float64(q.Allowance), float64(q.before))
+}
+
 // Stringer for CounterQuota, print the name and the used/allowed values
 func (q CounterQuota) String() string {
 	return fmt.Sprintf("CounterQuota(%s): Usage %d/%d %v", q.Name, q.used, q.Allowance, q.AbstractQuota)
 }
 
+// AmountQuota represents a quota based on the amount paid for the parking assigned rights, once the allowance
+// is used up the remaining time is not charged anymore
+type AmountQuota struct {
+	AbstractQuota `yaml:",inline"`
+	Allowance     Amount `yaml:"allowance"`
+	// Exceeded is the duration type (f or np) of the time not charged once the allowance is used up
+	Exceeded DurationType `yaml:"exceeded"`
+	used     Amount
+	before   Amount // Amount paid before the quote
+}
+
+func NewAmountQuota(name string, allowance Amount, exceeded DurationType, period timeutils.RecurrentDate, rules []MatchingRule) *AmountQuota {
+	return &AmountQuota{
+		AbstractQuota: AbstractQuota{
+			Name:                       name,
+			MatchingRules:              rules,
+			PeriodicityRule:            period,
+			DefaultTariffCodePattern:   "*",
+			DefaultLayerCodePattern:    "*",
+			DefaultFlagsPattern:        "*",
+			DefaultDurationTypePattern: "*",
+		},
+		Allowance: allowance,
+		Exceeded:  exceeded,
+	}
+}
+
+// Update updates the quota based on the history of assigned rights
+func (q *AmountQuota) Update(now time.Time, history AssignedRights, logger *slog.Logger) error {
+	total := Amount(0)
+	// Compute the start period of quota calculation
+	start, err := q.PeriodStart(now)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRecurrentRule, err)
+	}
+	// Compute the total amount paid for the matching assigned rights
+	err = q.Filter(start, history, logger, nil, func(detail DurationDetail) {
+		total += detail.Amount
+		logger.Debug("duration detail matches", "quota", q.Name, "type", detail.Type, "amount", detail.Amount, "start", detail.Start)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrQuotaMatching, err)
+	}
+	q.used, q.before = total, total
+	logger.Debug("amount quota updated", "quota", q.Name, "used", q.used, "allowance", q.Allowance)
+	return nil
+}
+
+// Clone returns a copy of the quota with its own usage state
+func (q *AmountQuota) Clone() Quota {
+	clone := *q
+	return &clone
+}
+
+// ContinueFrom takes over the usage of an amount quota
+func (q *AmountQuota) ContinueFrom(previous Quota) {
+	if prev, ok := previous.(*AmountQuota); ok {
+		q.used, q.before = prev.used, prev.before
+	}
+}
+
+func (q *AmountQuota) Available() Amount {
+	available := Amount(0)
+	if q.Allowance > q.used {
+		available = q.Allowance - q.used
+	}
+	return available
+}
+
+func (q *AmountQuota) Used() Amount {
+	return q.used
+}
+
+func (q *AmountQuota) IsExausted() bool {
+	return q.Available() <= 0
+}
+
+// UseDuration gives the whole duration as long as the allowance is not used up, the amount is only
+// used by UseAmount
+func (q *AmountQuota) UseDuration(duration time.Duration) time.Duration {
+	if q.IsExausted() {
+		return 0
+	}
+	return duration
+}
+
+// UseAmount uses the amount from the quota and returns the part of the amount which can be charged
+func (q *AmountQuota) UseAmount(amount Amount) Amount {
+	if amount > q.Available() {
+		amount = q.Available()
+	}
+	q.used += amount
+	return amount
+}
+
+// ToOutput reports the amount paid before the quote
+func (q *AmountQuota) ToOutput(now time.Time) (OutputQuota, error) {
+	return newOutputQuota(q, now, "amount", q.Allowance.Float64(), q.before.Float64())
+}
+
+// Stringer for AmountQuota, print the name and the used/allowed values
+func (q AmountQuota) String() string {
+	return fmt.Sprintf("AmountQuota(%s): Usage %s/%s %v", q.Name, q.used, q.Allowance, q.AbstractQuota)
+}
+
 type QuotaInventory map[string]Quota
 
-func (qi QuotaInventory) Update(now time.Time, history AssignedRights) error {
+func (qi QuotaInventory) Update(now time.Time, history AssignedRights, logger *slog.Logger) error {
 
 	// Iterate over all quotas and update them
 	for _, quota := range qi {
-		err := quota.Update(now, history)
+		err := quota.Update(now, history, logger)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to update quota %s: %w", quota.GetName(), err)
 		}
 	}
 	return nil
 }
 
+// Clone returns a copy of the inventory where each quota has its own usage state, this allows
+// to compute several tariffs concurrently from the same definition
+func (qi QuotaInventory) Clone() QuotaInventory {
+	clone := make(QuotaInventory, len(qi))
+	for name, quota := range qi {
+		clone[name] = quota.Clone()
+	}
+	return clone
+}
+
+// Continue adds the quotas of a tariff version to the inventory, each quota is updated depending on the history
+// then takes over the usage of the quota of the same name of the previous versions
+func (qi QuotaInventory) Continue(quotas QuotaInventory, now time.Time, history AssignedRights, logger *slog.Logger) error {
+	for name, quota := range quotas {
+		quota = quota.Clone()
+		if err := quota.Update(now, history, logger); err != nil {
+			return fmt.Errorf("failed to update quota %s: %w", quota.GetName(), err)
+		}
+		if previous, exists := qi[name]; exists {
+			quota.ContinueFrom(previous)
+		}
+		qi[name] = quota
+	}
+	return nil
+}
+
 // GetExpiryDate Return the parking right expiry date based on the longest quota periodicity
-func (qi QuotaInventory) GetExpiryDate(now time.Time) time.Time {
+func (qi QuotaInventory) GetExpiryDate(now time.Time) (time.Time, error) {
 	expiry := time.Time{}
 	for _, quota := range qi {
 		exp, err := quota.GetRightExpiryDate(now)
 		if err != nil {
-			fmt.Println("Error getting expiry date for quota", quota.GetName(), ":", err)
-			return expiry
+			return time.Time{}, fmt.Errorf("%w for quota %s expiry date: %w", ErrRecurrentRule, quota.GetName(), err)
 		}
 		if expiry.IsZero() || exp.After(expiry) {
 			expiry = exp
 		}
 	}
-	return expiry
+	return expiry, nil
+}
+
+// ToOutput reports the state of the quotas sorted by name, the usage of the table is the sum of the segments using
+// each quota, a counter quota being used once by the table
+func (qi QuotaInventory) ToOutput(now time.Time, table OutputSegments) ([]OutputQuota, error) {
+	var out []OutputQuota
+	for _, name := range slices.Sorted(maps.Keys(qi)) {
+		quota, err := qi[name].ToOutput(now)
+		if err != nil {
+			return nil, err
+		}
+		used, seconds, amount := false, 0, Amount(0)
+		var spans []timeutils.RelativeTimeSpan
+		from := time.Duration(0)
+		for _, seg := range table {
+			to := from + time.Duration(seg.Duration)*time.Second
+			if seg.Quota == name {
+				used, seconds, amount = true, seconds+seg.Duration, amount+seg.Amount
+				spans = append(spans, timeutils.RelativeTimeSpan{From: from, To: to})
+			}
+			from = to
+		}
+		switch {
+		case quota.Type == "duration":
+			quota.UsedByTable = float64(seconds)
+		case quota.Type == "amount":
+			quota.UsedByTable = amount.Float64()
+		case used:
+			quota.UsedByTable = 1
+		}
+		quota.Remaining = max(quota.Allowance-quota.UsedBefore-quota.UsedByTable, 0)
+		if rolling, ok := qi[name].(*DurationQuota); ok && rolling.Rolling > 0 {
+			quota.Remaining = rolling.remainingAfter(now, spans).Seconds()
+		}
+		out = append(out, quota)
+	}
+	return out, nil
 }
 
 // Stringer for QuotaInventory, iterate over all quotas and print some details
@@ -436,6 +832,7 @@ func (qi *QuotaInventory) UnmarshalYAML(ctx context.Context, unmarshal func(inte
 	temp := []struct {
 		DurationQuota *DurationQuota `yaml:"duration"`
 		CounterQuota  *CounterQuota  `yaml:"counter"`
+		AmountQuota   *AmountQuota   `yaml:"amount"`
 	}{}
 
 	// Unmarshal the quota into a temp struct
@@ -447,11 +844,24 @@ func (qi *QuotaInventory) UnmarshalYAML(ctx context.Context, unmarshal func(inte
 	*qi = make(QuotaInventory)
 	for _, t := range temp {
 		quota := Quota(nil)
-		// TODO return an error if both DurationQuota and CounterQuota are set
+		if !isOnlyOneFieldSet(t) {
+			return fmt.Errorf("several quota types set in one quota item")
+		}
 		if t.DurationQuota != nil {
-			quota = NewDurationQuota(t.DurationQuota.Name, t.DurationQuota.Allowance, t.DurationQuota.PeriodicityRule, t.DurationQuota.MatchingRules)
+			if t.DurationQuota.Rolling < 0 {
+				return fmt.Errorf("invalid rolling window %s for duration quota %s", t.DurationQuota.Rolling, t.DurationQuota.Name)
+			}
+			durationQuota := NewDurationQuota(t.DurationQuota.Name, t.DurationQuota.Allowance, t.DurationQuota.PeriodicityRule, t.DurationQuota.MatchingRules)
+			durationQuota.Rolling = t.DurationQuota.Rolling
+			quota = durationQuota
 		} else if t.CounterQuota != nil {
 			quota = NewCounterQuota(t.CounterQuota.Name, t.CounterQuota.Allowance, t.CounterQuota.PeriodicityRule, t.CounterQuota.MatchingRules)
+		} else if t.AmountQuota != nil {
+			exceeded := t.AmountQuota.Exceeded
+			if exceeded != FreeDuration && exceeded != NonPayingDuration {
+				return fmt.Errorf("invalid exceeded type %s for amount quota %s, must be f or np", exceeded.ShortString(), t.AmountQuota.Name)
+			}
+			quota = NewAmountQuota(t.AmountQuota.Name, t.AmountQuota.Allowance, exceeded, t.AmountQuota.PeriodicityRule, t.AmountQuota.MatchingRules)
 		}
 		if quota != nil {
 			if quota.GetName() == "" {
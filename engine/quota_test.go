@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"slices"
 	"testing"
 	"time"
 
@@ -298,7 +299,7 @@ func TestQuota_Update(t *testing.T) {
 
 			// Test the duration quota
 			durationQuota := NewDurationQuota("TestDurationQuota", time.Duration(0), tt.periodicityRule, tt.matchingRules)
-			err := durationQuota.Update(tt.now, tt.history)
+			err := durationQuota.Update(tt.now, tt.history, discardLogger())
 			if (err != nil) != tt.expectedError {
 				t.Fatalf("expected error %v, got %v", tt.expectedError, err)
 			}
@@ -309,7 +310,7 @@ func TestQuota_Update(t *testing.T) {
 
 			// Tests the counter quota
 			counterQuota := NewCounterQuota("TestCounterQuota", 0, tt.periodicityRule, tt.matchingRules)
-			err = counterQuota.Update(tt.now, tt.history)
+			err = counterQuota.Update(tt.now, tt.history, discardLogger())
 			if tt.expectedError {
 				t.Fatalf("expected error %v, got %v", tt.expectedError, err)
 			}
@@ -406,10 +407,80 @@ func TestQuotaInventory_GetExpiryDate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			expiryDate := tt.quotas.GetExpiryDate(tt.now)
+			expiryDate, err := tt.quotas.GetExpiryDate(tt.now)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("expected error %v, got %v", tt.expectedError, err)
+			}
 			if !expiryDate.Equal(tt.expectedDate) {
 				t.Errorf("expected expiry date %v, got %v", tt.expectedDate, expiryDate)
 			}
 		})
 	}
 }
+
+func TestDurationQuota_UseTimeSpanRolling(t *testing.T) {
+	now := time.Date(2023, 10, 10, 10, 0, 0, 0, time.Local)
+	// 2h free yesterday from 11:00 to 13:00
+	history := AssignedRights{
+		AssignedRight{
+			DurationDetails: []DurationDetail{
+				{Type: FreeDuration, Start: time.Date(2023, 10, 9, 11, 0, 0, 0, time.Local), Duration: 2 * time.Hour},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		spans      []timeutils.RelativeTimeSpan // timespans used in order
+		contiguous bool
+		expected   []timeutils.RelativeTimeSpan // granted parts of the last timespan
+		expiry     time.Time
+	}{
+		{
+			name:     "1 Exhausted, freed as the history leaves the window",
+			spans:    []timeutils.RelativeTimeSpan{{From: 0, To: 4 * time.Hour}},
+			expected: []timeutils.RelativeTimeSpan{{From: time.Hour, To: 3 * time.Hour}},
+			expiry:   time.Date(2023, 10, 11, 13, 0, 0, 0, time.Local),
+		},
+		{
+			name:       "2 Contiguous, nothing available at the start",
+			spans:      []timeutils.RelativeTimeSpan{{From: 0, To: 4 * time.Hour}},
+			contiguous: true,
+			expected:   nil,
+			expiry:     time.Date(2023, 10, 10, 13, 0, 0, 0, time.Local),
+		},
+		{
+			name:     "3 Freed allowance shared between timespans",
+			spans:    []timeutils.RelativeTimeSpan{{From: time.Hour, To: 90 * time.Minute}, {From: 2 * time.Hour, To: 5 * time.Hour}},
+			expected: []timeutils.RelativeTimeSpan{{From: 2 * time.Hour, To: 3*time.Hour + 30*time.Minute}},
+			expiry:   time.Date(2023, 10, 11, 13, 30, 0, 0, time.Local),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota := NewDurationQuota("rolling", 2*time.Hour, nil, nil)
+			quota.Rolling = 24 * time.Hour
+			if err := quota.Update(now, history, discardLogger()); err != nil {
+				t.Fatalf("failed to update quota: %v", err)
+			}
+			if quota.Used() != 2*time.Hour {
+				t.Errorf("expected used %v, got %v", 2*time.Hour, quota.Used())
+			}
+			var granted []timeutils.RelativeTimeSpan
+			for _, span := range tt.spans {
+				granted = quota.UseTimeSpan(span, tt.contiguous)
+			}
+			if !slices.Equal(granted, tt.expected) {
+				t.Errorf("expected granted %v, got %v", tt.expected, granted)
+			}
+			expiry, err := quota.GetRightExpiryDate(now)
+			if err != nil {
+				t.Fatalf("failed to get expiry date: %v", err)
+			}
+			if !expiry.Equal(tt.expiry) {
+				t.Errorf("expected expiry %v, got %v", tt.expiry, expiry)
+			}
+		})
+	}
+}
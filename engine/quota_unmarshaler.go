@@ -3,12 +3,13 @@ package engine
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
 
 // TODO rewrite this based on ast.Node
-func unmarshalQuota(quota *Quota, data []byte) error {
+func unmarshalQuota(quota *Quota, data []byte, loc *time.Location) error {
 	temp := struct {
 		Type string `yaml:"type"`
 	}{}
@@ -24,7 +25,7 @@ func unmarshalQuota(quota *Quota, data []byte) error {
 			DurationQuota `yaml:",inline"`
 			Type          string `yaml:"type"`
 		}{}
-		if err := yaml.UnmarshalWithOptions(data, &q, decoderOptions()...); err != nil {
+		if err := yaml.UnmarshalWithOptions(data, &q, decoderOptions(loc)...); err != nil {
 			return fmt.Errorf("failed to parse duration quota: %w", err)
 		}
 		*quota = &q.DurationQuota
@@ -33,10 +34,19 @@ func unmarshalQuota(quota *Quota, data []byte) error {
 			CounterQuota `yaml:",inline"`
 			Type         string `yaml:"type"`
 		}{}
-		if err := yaml.UnmarshalWithOptions(data, &q, decoderOptions()...); err != nil {
+		if err := yaml.UnmarshalWithOptions(data, &q, decoderOptions(loc)...); err != nil {
 			return fmt.Errorf("failed to parse counter quota: %w", err)
 		}
 		*quota = &q.CounterQuota
+	case "amount":
+		q := struct {
+			AmountQuota `yaml:",inline"`
+			Type        string `yaml:"type"`
+		}{}
+		if err := yaml.UnmarshalWithOptions(data, &q, decoderOptions(loc)...); err != nil {
+			return fmt.Errorf("failed to parse amount quota: %w", err)
+		}
+		*quota = &q.AmountQuota
 	default:
 		return fmt.Errorf("unknown quota type: %s", temp.Type)
 	}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -51,9 +52,10 @@ type Scheduler struct {
 	now     time.Time
 	window  time.Duration
 	entries *btree.BTreeG[SchedulerEntry]
+	logger  *slog.Logger
 }
 
-func NewScheduler() Scheduler {
+func NewScheduler(logger *slog.Logger) Scheduler {
 
 	// Sorting function for B-Tree storing all solver segments
 	RulesLess := func(i, j SchedulerEntry) bool {
@@ -62,6 +64,7 @@ func NewScheduler() Scheduler {
 
 	return Scheduler{
 		entries: btree.NewG(2, RulesLess),
+		logger:  logger,
 	}
 }
 
@@ -121,7 +124,7 @@ func (s *Scheduler) solveVsSingle(lpSpan SchedulerEntry, hpSpan SchedulerEntry)
 func (s *Scheduler) Append(lpEntry SchedulerEntry) {
 	var newEntries SchedulerEntries
 
-	fmt.Println("Solving scheduler entry", lpEntry, "from", lpEntry.From, "to", lpEntry.To)
+	s.logger.Debug("solve scheduler entry", "sequence", lpEntry.Sequence.Name, "from", lpEntry.From, "to", lpEntry.To)
 
 	// Loop over all entries and solve the current entry against each of them
 	s.entries.Ascend(func(hpEntry SchedulerEntry) bool {
@@ -150,12 +153,17 @@ func (s *Scheduler) Append(lpEntry SchedulerEntry) {
 	}
 }
 
-func (s *Scheduler) AddSequence(seq *TariffSequence) {
-	for _, seg := range seq.ValidityPeriod.Between(s.now, s.now.Add(s.window)) {
+func (s *Scheduler) AddSequence(seq *TariffSequence) error {
+	segments, err := seq.ValidityPeriod.Between(s.now, s.now.Add(s.window))
+	if err != nil {
+		return fmt.Errorf("%w for sequence %s validity period: %w", ErrRecurrentRule, seq.Name, err)
+	}
+	for _, seg := range segments {
 		timespan := seg.ToRelativeTimeSpan(s.now)
 		if timespan.From < 0 {
 			timespan.From = 0
 		}
 		s.Append(SchedulerEntry{timespan, seq})
 	}
+	return nil
 }
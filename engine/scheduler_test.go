@@ -99,7 +99,7 @@ func TestSchedulerAppend(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			scheduler := NewScheduler()
+			scheduler := NewScheduler(discardLogger())
 			for _, entry := range tt.entries {
 				scheduler.Append(entry)
 			}
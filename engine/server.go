@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// maxRequestSize limits the size of the request bodies, histories included
+const maxRequestSize = 1 << 20
+
+// LoadTariffDirectory parses all the tariff files (*.yaml) of a directory, the tariffs are identified by
+// their file name without extension
+func LoadTariffDirectory(dir string) (map[string]TariffDefinition, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	tariffs := make(map[string]TariffDefinition)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".yaml" {
+			continue
+		}
+		tariff, err := ParseTariffDefinitionFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tariff %s: %w", file.Name(), err)
+		}
+		tariffs[fileNameWithoutExtension(file.Name())] = tariff
+	}
+	return tariffs, nil
+}
+
+// Server is an HTTP JSON quoting service computing the tables of a set of tariffs:
+//
+//	GET  /tariffs   list the loaded tariffs
+//	POST /quote     compute the table of a tariff
+//	POST /amount    amount to pay for a duration or an end date
+//	POST /duration  duration and end date bought with an amount
+//
+// The tariffs are never modified once loaded and each request computes its own table, so the requests
+// are served concurrently.
+type Server struct {
+	tariffs map[string]TariffDefinition
+	logger  *slog.Logger
+	mux     *http.ServeMux
+}
+
+// NewServer creates a quoting service for the given tariffs, a nil logger logs nothing
+func NewServer(tariffs map[string]TariffDefinition, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = discardLogger()
+	}
+	s := &Server{
+		tariffs: tariffs,
+		logger:  logger,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /tariffs", s.handleTariffs)
+	s.mux.HandleFunc("POST /quote", s.handleQuote)
+	s.mux.HandleFunc("POST /amount", s.handleAmount)
+	s.mux.HandleFunc("POST /duration", s.handleDuration)
+	return s
+}
+
+// ServeHTTP serves the requests, a request crashing the computation is answered with an internal error
+// rather than stopping the service
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		s.logger.Error("request panicked", "panic", v, "stack", string(debug.Stack()))
+		s.writeError(w, fmt.Errorf("internal error"))
+	}()
+	s.mux.ServeHTTP(w, r)
+}
+
+// TariffInfo describes a loaded tariff in the GET /tariffs response
+type TariffInfo struct {
+	ID       string `json:"id"`
+	Window   int    `json:"window"` // Window as seconds
+	Timezone string `json:"timezone"`
+}
+
+// QuoteRequest is the body of POST /quote, now is the current time if not set
+type QuoteRequest struct {
+	Tariff   string          `json:"tariff"`
+	Now      time.Time       `json:"now"`
+	History  AssignedRights  `json:"history"`
+	Profile  CustomerProfile `json:"profile"`
+	CashStep *Amount         `json:"cashstep"` // Smallest amount which can be paid, see WithCashStep
+}
+
+// PointRequest is the body of POST /amount and POST /duration. The query is answered against the given
+// table, or against the table computed from the quote fields if there is none.
+type PointRequest struct {
+	QuoteRequest
+	Table    *Output   `json:"table"`
+	Amount   *Amount   `json:"amount"`
+	Duration *int      `json:"duration"` // Duration as seconds
+	End      time.Time `json:"end"`
+}
+
+// PointResponse is the answer of POST /amount and POST /duration
+type PointResponse struct {
+	Amount   Amount    `json:"amount"`
+	Duration int       `json:"duration"` // Duration as seconds
+	End      time.Time `json:"end"`
+}
+
+// httpError is an error answered with a specific HTTP status
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func (s *Server) handleTariffs(w http.ResponseWriter, r *http.Request) {
+	infos := make([]TariffInfo, 0, len(s.tariffs))
+	for id, tariff := range s.tariffs {
+		infos = append(infos, TariffInfo{
+			ID:       id,
+			Window:   int(tariff.Config.Window.Seconds()),
+			Timezone: tariff.Config.Location().String(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	s.writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) handleQuote(w http.ResponseWriter, r *http.Request) {
+	var req QuoteRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	out, err := s.quote(req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleAmount(w http.ResponseWriter, r *http.Request) {
+	var req PointRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if req.Duration == nil && req.End.IsZero() {
+		s.writeError(w, badRequest("duration or end is required"))
+		return
+	}
+	out, err := s.table(req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != nil {
+		duration = time.Duration(*req.Duration) * time.Second
+	} else {
+		duration = req.End.Sub(out.Now)
+	}
+	if duration < 0 {
+		s.writeError(w, badRequest("end date %s is before table reference time %s", req.End.Format(time.RFC3339), out.Now.Format(time.RFC3339)))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, PointResponse{
+		Amount:   out.AmountForDuration(duration),
+		Duration: int(duration.Seconds()),
+		End:      out.Now.Add(duration),
+	})
+}
+
+func (s *Server) handleDuration(w http.ResponseWriter, r *http.Request) {
+	var req PointRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if req.Amount == nil {
+		s.writeError(w, badRequest("amount is required"))
+		return
+	}
+	if *req.Amount < 0 {
+		s.writeError(w, badRequest("negative amount: %s", *req.Amount))
+		return
+	}
+	out, err := s.table(req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, PointResponse{
+		Amount:   *req.Amount,
+		Duration: int(out.DurationForAmount(*req.Amount).Seconds()),
+		End:      out.EndDateForAmount(*req.Amount),
+	})
+}
+
+// quote computes the table of the requested tariff
+func (s *Server) quote(req QuoteRequest) (Output, error) {
+	tariff, ok := s.tariffs[req.Tariff]
+	if !ok {
+		return Output{}, &httpError{http.StatusNotFound, fmt.Errorf("unknown tariff: %q", req.Tariff)}
+	}
+	now := req.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	options := []ComputeOption{WithProfile(req.Profile), WithLogger(s.logger.With("tariff", req.Tariff))}
+	if req.CashStep != nil {
+		if err := ValidateCashStep(*req.CashStep); err != nil {
+			return Output{}, &httpError{http.StatusBadRequest, err}
+		}
+		options = append(options, WithCashStep(*req.CashStep))
+	}
+	out, err := tariff.Compute(now, req.History, options...)
+	if err != nil {
+		return Output{}, &httpError{http.StatusUnprocessableEntity, fmt.Errorf("failed to compute tariff %s: %w", req.Tariff, err)}
+	}
+	return out, nil
+}
+
+// table returns the table given in the request or computes it
+func (s *Server) table(req PointRequest) (Output, error) {
+	if req.Table != nil {
+		return *req.Table, nil
+	}
+	return s.quote(req.QuoteRequest)
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return &httpError{http.StatusRequestEntityTooLarge, err}
+		}
+		return badRequest("invalid request: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Error("failed to encode response", "error", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		status = httpErr.status
+	}
+	s.logger.Info("request failed", "status", status, "error", err)
+	s.writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	tariffs, err := LoadTariffDirectory("testdata/devs")
+	if err != nil {
+		t.Fatalf("failed to load tariffs: %v", err)
+	}
+	server := httptest.NewServer(NewServer(tariffs, nil))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServerTariffs(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, err := http.Get(server.URL + "/tariffs")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var infos []TariffInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	files, err := filepath.Glob("testdata/devs/*.yaml")
+	if err != nil {
+		t.Fatalf("failed to list tariffs: %v", err)
+	}
+	if len(infos) != len(files) {
+		t.Fatalf("expected %d tariffs, got %d", len(files), len(infos))
+	}
+	for i, info := range infos {
+		if expected := fileNameWithoutExtension(filepath.Base(files[i])); info.ID != expected {
+			t.Errorf("expected tariff %s, got %s", expected, info.ID)
+		}
+		if info.ID == "timezone_dst" && (info.Timezone != "Europe/Paris" || info.Window != 48*3600) {
+			t.Errorf("unexpected tariff info %+v", info)
+		}
+	}
+}
+
+func TestServerRequests(t *testing.T) {
+	server := newTestServer(t)
+	tariff, err := ParseTariffDefinitionFile("testdata/devs/test_date.yaml")
+	if err != nil {
+		t.Fatalf("failed to parse tariff definition: %v", err)
+	}
+	now := time.Date(2025, 4, 19, 20, 0, 0, 0, time.Local)
+	table, err := tariff.Compute(now, nil)
+	if err != nil {
+		t.Fatalf("failed to compute tariff: %v", err)
+	}
+	expectedTable, err := table.ToJson()
+	if err != nil {
+		t.Fatalf("failed to convert table to JSON: %v", err)
+	}
+
+	tests := map[string]struct {
+		path     string
+		body     string
+		status   int
+		expected string
+	}{
+		"0-Quote": {
+			path:     "/quote",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `"}`,
+			status:   http.StatusOK,
+			expected: string(expectedTable),
+		},
+		"1-AmountForDuration": {
+			path:     "/amount",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `", "duration": 7200}`,
+			status:   http.StatusOK,
+			expected: `{"amount":2,"duration":7200,"end":"` + now.Add(2*time.Hour).Format(time.RFC3339) + `"}`,
+		},
+		"2-AmountForEndDate": {
+			path:     "/amount",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `", "end": "` + now.Add(34*time.Hour).Format(time.RFC3339) + `"}`,
+			status:   http.StatusOK,
+			expected: `{"amount":10,"duration":122400,"end":"` + now.Add(34*time.Hour).Format(time.RFC3339) + `"}`,
+		},
+		"3-DurationForAmountFromTable": {
+			path:     "/duration",
+			body:     `{"table": ` + string(expectedTable) + `, "amount": 1.5}`,
+			status:   http.StatusOK,
+			expected: `{"amount":1.5,"duration":5400,"end":"` + now.Add(90*time.Minute).Format(time.RFC3339) + `"}`,
+		},
+		"4-UnknownTariff": {
+			path:     "/quote",
+			body:     `{"tariff": "unknown"}`,
+			status:   http.StatusNotFound,
+			expected: `{"error":"unknown tariff: \"unknown\""}`,
+		},
+		"5-InvalidBody": {
+			path:     "/quote",
+			body:     `{"tariff": "test_date", "unknown": 1}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":"invalid request: json: unknown field \"unknown\""}`,
+		},
+		"6-MissingAmount": {
+			path:     "/duration",
+			body:     `{"tariff": "test_date"}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":"amount is required"}`,
+		},
+		"7-EndBeforeNow": {
+			path:     "/amount",
+			body:     `{"table": ` + string(expectedTable) + `, "end": "` + now.Add(-time.Hour).Format(time.RFC3339) + `"}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":"end date ` + now.Add(-time.Hour).Format(time.RFC3339) + ` is before table reference time ` + now.Format(time.RFC3339) + `"}`,
+		},
+		"8-AmountWithProfile": {
+			path:     "/amount",
+			body:     `{"tariff": "profile_variants", "now": "` + now.Format(time.RFC3339) + `", "profile": {"flags": ["pmr"]}, "duration": 10800}`,
+			status:   http.StatusOK,
+			expected: `{"amount":1,"duration":10800,"end":"` + now.Add(3*time.Hour).Format(time.RFC3339) + `"}`,
+		},
+		"9-AmountWithCashStep": {
+			path:     "/amount",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `", "cashstep": 0.5, "duration": 4000}`,
+			status:   http.StatusOK,
+			expected: `{"amount":1.5,"duration":4000,"end":"` + now.Add(4000*time.Second).Format(time.RFC3339) + `"}`,
+		},
+		"10-EmptyLinearSegment": {
+			path:     "/amount",
+			body:     `{"table": {"now": "` + now.Format(time.RFC3339) + `", "table": [{"d": 0, "a": 1, "l": true, "dt": "p"}, {"d": 3600, "a": 2, "l": true, "dt": "p"}]}, "duration": 1800}`,
+			status:   http.StatusOK,
+			expected: `{"amount":2,"duration":1800,"end":"` + now.Add(30*time.Minute).Format(time.RFC3339) + `"}`,
+		},
+		"11-ZeroCashStep": {
+			path:     "/quote",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `", "cashstep": 0}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":"invalid cash step 0.000000, it must be at least 0.01"}`,
+		},
+		"12-CashStepBelowMinimum": {
+			path:     "/amount",
+			body:     `{"tariff": "test_date", "now": "` + now.Format(time.RFC3339) + `", "cashstep": 0.000001, "duration": 4000}`,
+			status:   http.StatusBadRequest,
+			expected: `{"error":"invalid cash step 0.000001, it must be at least 0.01"}`,
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+testcase.path, "application/json", strings.NewReader(testcase.body))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			if resp.StatusCode != testcase.status {
+				t.Errorf("expected status %d, got %d", testcase.status, resp.StatusCode)
+			}
+			if got := string(bytes.TrimSpace(body)); got != testcase.expected {
+				t.Errorf("unexpected response:\ngot      %s\nexpected %s", got, testcase.expected)
+			}
+		})
+	}
+}
+
+func TestServerMethodNotAllowed(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, err := http.Get(server.URL + "/quote")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerRecoversFromPanic(t *testing.T) {
+	s := NewServer(nil, nil)
+	s.mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("computation failure")
+	})
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || string(bytes.TrimSpace(body)) != `{"error":"internal error"}` {
+		t.Errorf("expected an internal error, got %d %s", resp.StatusCode, body)
+	}
+}
+
+// Concurrent quotes on the same tariff must return the same tables as sequential ones
+func TestServerConcurrentQuotes(t *testing.T) {
+	server := newTestServer(t)
+	history, err := LoadHistoryFromFile("testdata/devs/history1.rights")
+	if err != nil {
+		t.Fatalf("failed to load history from file: %v", err)
+	}
+
+	quote := func(now time.Time, history AssignedRights) (string, error) {
+		body, err := json.Marshal(QuoteRequest{Tariff: "durationquota_filtering", Now: now, History: history})
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.Post(server.URL+"/quote", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(resp.Body)
+		return string(out), err
+	}
+
+	nows := []time.Time{
+		time.Date(2025, 3, 17, 6, 0, 0, 0, time.Local),
+		time.Date(2025, 3, 17, 19, 30, 0, 0, time.Local),
+	}
+	var expected []string
+	for _, now := range nows {
+		out, err := quote(now, history)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		expected = append(expected, out)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for j, now := range nows {
+			wg.Add(1)
+			go func(now time.Time, expected string) {
+				defer wg.Done()
+				out, err := quote(now, history)
+				if err != nil {
+					t.Errorf("request failed: %v", err)
+					return
+				}
+				if out != expected {
+					t.Errorf("table mismatch for now %v:\ngot %s\nexpected %s", now, out, expected)
+				}
+			}(now, expected[j])
+		}
+	}
+	wg.Wait()
+}
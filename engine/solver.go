@@ -2,14 +2,21 @@ package engine
 
 import (
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/google/btree"
 	"github.com/iem-rd/quote-engine/timeutils"
 )
 
+// InterpolAmountNoOffset returns the amount of the rule charged after the given time, an empty rule is charged
+// as a whole
 func InterpolAmountNoOffset(rule SolverRule, at time.Duration) Amount {
-	return Amount(float64(rule.EndAmount-rule.StartAmount) * float64(at) / float64(rule.Duration()))
+	if rule.Duration() == 0 {
+		return rule.EndAmount - rule.StartAmount
+	}
+	return (rule.EndAmount - rule.StartAmount).MulDuration(at, rule.Duration())
 }
 
 func InterpolAmount(rule SolverRule, at time.Duration) Amount {
@@ -39,12 +46,10 @@ func (rule SolverRule) TruncateBefore(before time.Duration) SolverRule {
 	ruleA.From = before
 	ruleA.Trace = append(rule.Trace, fmt.Sprintf("truncate before %s", before.String()))
 	ruleA.StartAmount = 0
+	ruleA.Elapsed += before - rule.From
 
 	if rule.Duration() != time.Duration(0) {
 		ruleA.EndAmount = InterpolAmountNoOffset(rule, ruleA.Duration())
-		// TODO: review all amount type with float or decimal library
-		// ex: go run . processor -f samples/tariff_PortValais_t3.json -n 2024-11-28T16:13:00
-		//     go run . player -f output/table.json -a 150
 	}
 	return ruleA
 }
@@ -68,6 +73,7 @@ func (rule SolverRule) Split(splitStart, splitEnd time.Duration) SolverRules {
 
 	ruleB.StartAmount = 0
 	ruleB.EndAmount = rule.EndAmount - ruleA.EndAmount
+	ruleB.Elapsed += splitStart - rule.From
 
 	return SolverRules{ruleA, ruleB}
 }
@@ -78,7 +84,11 @@ func (rule *SolverRule) And(timespan timeutils.RelativeTimeSpan) (*SolverRule, b
 		return rule, true
 		// rule is longer than timespan (timespan fully inside rule), then rule beginning and end are truncated
 	} else if rule.From <= timespan.From && rule.To >= timespan.To {
-		r := rule.TruncateAfter(timespan.To).TruncateBefore(timespan.From)
+		// A step beginning with the timespan is charged in it, it is not truncated before
+		r := rule.TruncateAfter(timespan.To)
+		if rule.From < timespan.From {
+			r = r.TruncateBefore(timespan.From)
+		}
 		r.Trace = append(r.Trace, "truncated for sequence merging")
 		return &r, true
 		// rule is partially at the end of timespan, then rule end is truncated
@@ -98,47 +108,190 @@ func (rule *SolverRule) And(timespan timeutils.RelativeTimeSpan) (*SolverRule, b
 
 func (rule *SolverRule) DurationForAmount(amount Amount) time.Duration {
 	//fmt.Println(" >> DurationForAmount", rule.Name(), amount, rule.StartAmount, rule.EndAmount, rule.Duration())
-	return rule.From + time.Duration(float64(rule.Duration())*float64(amount)/float64(rule.EndAmount-rule.StartAmount))
+	// A step rule is charged as a whole at its beginning
+	if rule.EndAmount == rule.StartAmount {
+		return rule.From
+	}
+	// Amounts are rounded to micro units, round the duration to the second to remove the rounding noise
+	at := time.Duration(mulDiv(int64(rule.Duration()), int64(amount), int64(rule.EndAmount-rule.StartAmount))).Round(time.Second)
+	return rule.From + min(max(at, 0), rule.Duration())
 }
 
 func (rule SolverRule) TruncateAfterAmount(amount Amount) SolverRule {
 	if amount >= rule.EndAmount {
 		return rule
 	}
+	// A rule with a granularity is truncated before the first step which cannot be paid
+	if rule.Granularity > 0 {
+		sum := Amount(0)
+		for _, step := range rule.Steps() {
+			if sum+step.EndAmount > amount {
+				truncated := rule.TruncateAfter(step.From)
+				truncated.Trace = append(truncated.Trace, fmt.Sprintf("truncate after amount %s", amount.String()))
+				return truncated
+			}
+			sum += step.EndAmount
+		}
+		return rule
+	}
 	rule.To = rule.DurationForAmount(amount)
 	rule.EndAmount = amount
 	if rule.StartAmount > rule.EndAmount {
 		rule.StartAmount = rule.EndAmount
 	}
-	fmt.Println(" >> TruncateAfterAmount", amount, "->", rule.To)
 	rule.Trace = append(rule.Trace, fmt.Sprintf("truncate after amount %s", amount.String()))
 	return rule
 }
 
-// Update the rule taking into account the quota
-func (rule SolverRule) ApplyQuota() SolverRule {
+// Steps expands a rule with a granularity in a step rule for each unit charged, preceded by a free rule if the
+// rule begins before its first charge. The units are aligned on the beginning of the original rule, so a unit
+// started before a split or a truncation is not charged again by the next part of the rule.
+func (rule SolverRule) Steps() SolverRules {
+	if rule.Granularity <= 0 || rule.Duration() <= 0 {
+		return SolverRules{rule}
+	}
+
+	// The table is precise to the second, a unit rounded down is charged at the last second of the unit and a
+	// unit rounded to the nearest at the last second of its first half
+	first := time.Duration(0)
+	switch rule.Rounding {
+	case RoundDown:
+		first = max(rule.Granularity-time.Second, 0)
+	case RoundNearest:
+		first = max(rule.Granularity/2-time.Second, 0)
+	}
+	// The next charge of a unit at or after the beginning of this part
+	if rule.Elapsed > first {
+		first += (rule.Elapsed - first + rule.Granularity - 1) / rule.Granularity * rule.Granularity
+	}
+	start := rule.From + first - rule.Elapsed
+
+	base := rule
+	base.Granularity = 0
+	base.Trace = slices.Clip(rule.Trace)
+	out := SolverRules{}
+	if start > rule.From {
+		head := base
+		head.To = min(start, rule.To)
+		head.StartAmount, head.EndAmount = 0, 0
+		head.Trace = append(head.Trace, "granularity, not charged")
+		out = append(out, head)
+	}
+	for from := start; from < rule.To; from += rule.Granularity {
+		step := base
+		step.From = from
+		step.To = min(from+rule.Granularity, rule.To)
+		step.StartAmount, step.EndAmount = rule.StepAmount, rule.StepAmount
+		step.Trace = append(step.Trace, fmt.Sprintf("granularity step no%d", (rule.Elapsed+from-rule.From)/rule.Granularity))
+		out = append(out, step)
+	}
+	return out
+}
+
+// Update the rule taking into account the quota, the rule is removed if no duration is available and a rule with
+// a rolling quota is split where the quota is used up
+func (rule SolverRule) ApplyQuota(logger *slog.Logger) SolverRules {
+	if quota, ok := rule.Quota.(*DurationQuota); ok && quota.Rolling > 0 {
+		logger.Debug("apply rolling quota", "rule", rule.Name(), "quota", rule.Quota)
+		// Only the fixed rules keep their place, a shiftable rule uses the quota from its start and a step is
+		// charged only if it is granted as a whole
+		step := rule.IsFlatRate() && rule.EndAmount > 0
+		spans := quota.UseTimeSpan(rule.RelativeTimeSpan, step || rule.StartTimePolicy != FixedPolicy)
+		if step && (len(spans) == 0 || spans[0] != rule.RelativeTimeSpan) {
+			logger.Debug("quota is partially available, flatrate rule removed", "rule", rule.Name(), "quota", quota.GetName())
+			return SolverRules{}
+		}
+		return rule.ExtractTimeSpans(spans, fmt.Sprintf("quota %s used up", quota.GetName()))
+	}
+	return SolverRules{rule.applyQuota(logger)}
+}
+
+// Fallback lays out the otherwise rules over the time of the rule not covered by the parts given by its quota. The
+// otherwise rules follow each other and take the place and policies of the rule, a linear rule is charged for the
+// time it covers while a step is charged as a whole.
+func (rule SolverRule) Fallback(parts SolverRules) SolverRules {
+	if len(rule.Otherwise) == 0 {
+		return nil
+	}
+	missing := []timeutils.RelativeTimeSpan{rule.RelativeTimeSpan}
+	for _, part := range parts {
+		if !part.IsEmpty() {
+			missing = subtractTimeSpan(missing, part.RelativeTimeSpan)
+		}
+	}
+
+	out := SolverRules{}
+	for _, otherwise := range rule.Otherwise {
+		if len(missing) == 0 {
+			break
+		}
+		otherwise.StartTimePolicy = rule.StartTimePolicy
+		otherwise.RuleResolutionPolicy = rule.RuleResolutionPolicy
+		otherwise.Trace = append(slices.Clip(otherwise.Trace), fmt.Sprintf("otherwise for %s", rule.Name()))
+		otherwise = otherwise.Shift(missing[0].From)
+		// Split the rule over the missing timespans, the remaining part goes on at the next timespan
+		for otherwise.Duration() > 0 && len(missing) > 0 {
+			if otherwise.To <= missing[0].To {
+				out = append(out, otherwise)
+				missing[0].From = otherwise.To
+				if missing[0].Duration() == 0 {
+					missing = missing[1:]
+				}
+				break
+			}
+			otherwise.Trace = slices.Clip(otherwise.Trace)
+			out = append(out, otherwise.TruncateAfter(missing[0].To))
+			otherwise = otherwise.TruncateBefore(missing[0].To)
+			missing = missing[1:]
+			if len(missing) > 0 {
+				otherwise = otherwise.Shift(missing[0].From)
+			}
+		}
+	}
+	return out
+}
+
+// subtractTimeSpan removes the timespan from the sorted timespans
+func subtractTimeSpan(spans []timeutils.RelativeTimeSpan, span timeutils.RelativeTimeSpan) []timeutils.RelativeTimeSpan {
+	out := make([]timeutils.RelativeTimeSpan, 0, len(spans)+1)
+	for _, s := range spans {
+		if s.To <= span.From || s.From >= span.To {
+			out = append(out, s)
+			continue
+		}
+		if s.From < span.From {
+			out = append(out, timeutils.RelativeTimeSpan{From: s.From, To: span.From})
+		}
+		if s.To > span.To {
+			out = append(out, timeutils.RelativeTimeSpan{From: span.To, To: s.To})
+		}
+	}
+	return out
+}
+
+func (rule SolverRule) applyQuota(logger *slog.Logger) SolverRule {
 	if rule.Quota != nil {
-		fmt.Println(" >> ApplyQuota", rule.Name(), rule.Quota)
+		logger.Debug("apply quota", "rule", rule.Name(), "quota", rule.Quota)
 
 		// If quota is exhausted, then remove the rule
 		if rule.Quota.IsExausted() {
-			fmt.Println(" >> quota is exhausted", rule.Name())
+			logger.Debug("quota is exhausted, rule removed", "rule", rule.Name(), "quota", rule.Quota.GetName())
 			return SolverRule{}
 		}
 
 		duration := rule.Quota.UseDuration(rule.Duration())
-		fmt.Println(" >> quota use duration", rule.Name(), "for", duration, "of", rule.Duration())
+		logger.Debug("quota used", "rule", rule.Name(), "quota", rule.Quota.GetName(), "used", duration, "duration", rule.Duration())
 		if duration == 0 {
-			fmt.Println(" >> quota is exhausted (duration)", rule.Name())
+			logger.Debug("quota is exhausted (duration), rule removed", "rule", rule.Name(), "quota", rule.Quota.GetName())
 			return SolverRule{}
 		}
 		// if the quota available duration is smaller than rule duration
 		if duration != rule.Duration() {
-			fmt.Println(" >> quota is truncated", rule.Name(), "from", rule.Duration(), "to", duration)
-			if rule.IsFlatRate() {
+			logger.Debug("quota is partially available, rule truncated", "rule", rule.Name(), "quota", rule.Quota.GetName(), "from", rule.Duration(), "to", duration)
+			if rule.IsFlatRate() && rule.EndAmount > 0 {
 				return SolverRule{}
 			} else {
-				return rule.TruncateAfter(duration)
+				return rule.TruncateAfter(rule.From + duration)
 			}
 		}
 	}
@@ -148,10 +301,17 @@ func (rule SolverRule) ApplyQuota() SolverRule {
 type Solver struct {
 	now            time.Time
 	window         time.Duration
+	quotasFrom     time.Duration // Start of the quotas usage, see SetQuotas
+	quotas         QuotaInventory
+	logger         *slog.Logger
+	dump           *RulesDump
 	flatrateRules  *btree.BTreeG[*SolverRule]
 	fixedRules     *btree.BTreeG[*SolverRule]
 	shiftableRules []*SolverRule
+	graceRules     SolverRules // Retroactive grace rules, applied to the solved rules
+	overlayRules   SolverRules // Overlay rules, applied to the solved rules before the grace rules
 	solvedRules    *btree.BTreeG[*SolverRule]
+	err            error // First error met while appending or solving the rules
 }
 
 func NewSolver() Solver {
@@ -169,6 +329,7 @@ func NewSolver() Solver {
 	}
 
 	return Solver{
+		logger: discardLogger(),
 		//rules:       btree.NewG(2, RulesLess),
 		flatrateRules: btree.NewG(2, RulesLess),
 		solvedRules:   btree.NewG(2, RulesLess),
@@ -181,20 +342,86 @@ func (s *Solver) SetWindow(now time.Time, window time.Duration) {
 	s.window = window
 }
 
+// SetQuotas sets the quotas inventory used by the solver, the rules quotas are replaced by the
+// quotas of the same name from this inventory so the usage state is not shared between solvers. The
+// rules before from were given by a previous tariff version, they keep their place without using the
+// quotas again.
+func (s *Solver) SetQuotas(quotas QuotaInventory, from time.Duration) {
+	s.quotas = quotas
+	s.quotasFrom = from
+}
+
+// SetLogger sets the logger used for the solving traces and the optional rules dump
+func (s *Solver) SetLogger(logger *slog.Logger, dump *RulesDump) {
+	s.logger = logger
+	s.dump = dump
+}
+
 func (s *Solver) AppendMany(rules ...SolverRule) {
 	for i := range rules {
 		s.Append(rules[i])
 	}
 }
 
+// Append a rule to the solver, an invalid rule stops the solver and the error is returned by Solve
 func (s *Solver) Append(rule SolverRule) {
 
-	rule = rule.ApplyQuota()
-	if rule.IsEmpty() {
+	if s.err != nil {
 		return
 	}
+	if !rule.IsValid() {
+		s.err = fmt.Errorf("%w %s for rule %s", ErrInvalidTimespan, rule.RelativeTimeSpan, rule.Name())
+		return
+	}
+
+	if rule.Quota != nil && s.quotas != nil {
+		if quota, exists := s.quotas[rule.Quota.GetName()]; exists {
+			rule.Quota = quota
+		}
+	}
+	// The quota is only used by the part of the rule inside the window, the rest is not kept in the output
+	if rule.Quota != nil && s.window > 0 {
+		if rule.To > s.window {
+			rule = rule.TruncateAfter(s.window)
+		}
+		if rule.From < 0 {
+			rule = rule.TruncateBefore(0)
+		}
+		if rule.From >= rule.To {
+			return
+		}
+		if rule.From < s.quotasFrom {
+			rule.Trace = slices.Clip(rule.Trace)
+			head := rule.TruncateAfter(min(rule.To, s.quotasFrom))
+			head.Quota, head.Otherwise = nil, nil
+			s.appendSolvable(head)
+			if rule.To <= s.quotasFrom {
+				return
+			}
+			rule = rule.TruncateBefore(s.quotasFrom)
+		}
+	}
+	parts := rule.ApplyQuota(s.logger)
+	for _, part := range parts {
+		if !part.IsEmpty() {
+			s.appendSolvable(part)
+		}
+	}
+	// The otherwise rules may have a quota of their own, they are appended like the other rules
+	for _, fallback := range rule.Fallback(parts) {
+		s.Append(fallback)
+	}
+}
 
-	if rule.ActivationAmount > 0 {
+// appendSolvable stores the rule in the collection matching its policy
+func (s *Solver) appendSolvable(rule SolverRule) {
+	if rule.Retroactive {
+		// retroactive grace rules take no time, they are applied once the rules are solved
+		s.graceRules = append(s.graceRules, rule)
+	} else if rule.Overlay != nil {
+		// so are the overlay rules
+		s.overlayRules = append(s.overlayRules, rule)
+	} else if rule.ActivationAmount > 0 {
 		// flatrate rules are stored in a b-tree
 		s.flatrateRules.ReplaceOrInsert(&rule)
 	} else if rule.StartTimePolicy == FixedPolicy {
@@ -207,22 +434,27 @@ func (s *Solver) Append(rule SolverRule) {
 	}
 }
 
-func (s *Solver) Solve() {
-	tbl := StartRulesTable("flatrates rules", s.now)
+// Solve all appended rules, the first error met while appending or solving the rules is returned
+func (s *Solver) Solve() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	tbl := s.dump.StartRulesTable("flatrates rules", s.now)
 	s.flatrateRules.Ascend(func(rule *SolverRule) bool {
 		tbl.AddRule(rule)
 		return true
 	})
 	tbl.Print()
 
-	tbl = StartRulesTable("fixed rules", s.now)
+	tbl = s.dump.StartRulesTable("fixed rules", s.now)
 	s.fixedRules.Ascend(func(rule *SolverRule) bool {
 		tbl.AddRule(rule)
 		return true
 	})
 	tbl.Print()
 
-	tbl = StartRulesTable("shiftable rules", s.now)
+	tbl = s.dump.StartRulesTable("shiftable rules", s.now)
 	for i := range s.shiftableRules {
 		tbl.AddRule(s.shiftableRules[i])
 	}
@@ -234,11 +466,15 @@ func (s *Solver) Solve() {
 	// Solve all shiftable rules against all fixed rules
 	for i := range s.shiftableRules {
 		s.solveShiftableVsFixedRules(s.shiftableRules[i])
+		if s.err != nil {
+			return s.err
+		}
 	}
 
 	// Solve potential continuous fixed rules at the end of the last rule
 	_, start := s.sumAllSolvedRules()
 	s.SolveContinousFixedRules(start)
+	return nil
 }
 
 // SolveContinousFixedRules appends all potentially continous fixed rules in the solved rules collection
@@ -261,12 +497,12 @@ func (s *Solver) SolveContinousFixedRules(start time.Duration) {
 // Solve the rule against an Higer Priority Rule resolving the conflict according to rule policy
 // a collection of new rules containing 0, 1, or 2 rules is returned and current rule is not changed
 // the second return value is true if the rule has intersected and has been changed, false if untouched
-func (s *Solver) solveVsSingle(lpRule SolverRule, hpRule *SolverRule) (SolverRules, bool) {
+func (s *Solver) solveVsSingle(lpRule SolverRule, hpRule *SolverRule) (SolverRules, bool, error) {
 
 	// trivial case, both rules don't overlap
 	if (hpRule.To <= lpRule.From) ||
 		(hpRule.From >= lpRule.To) {
-		return SolverRules{lpRule}, false
+		return SolverRules{lpRule}, false, nil
 	}
 
 	//fmt.Println(" >> solveVsSingle", lpRule.Name(), "vs", hpRule.Name())
@@ -277,7 +513,7 @@ func (s *Solver) solveVsSingle(lpRule SolverRule, hpRule *SolverRule) (SolverRul
 	// both rules overlap at least slightly, if policy is 'remove' then remove the low priority rule
 	case DeletePolicy:
 		//fmt.Println("    DeletePolicy", lpRule.Name(), "vs", hpRule.Name())
-		return SolverRules{}, true
+		return SolverRules{}, true, nil
 
 	// both rules overlap at least slightly, if policy is 'resolve' then try to split rule to fill the holes
 	case ResolvePolicy:
@@ -285,10 +521,10 @@ func (s *Solver) solveVsSingle(lpRule SolverRule, hpRule *SolverRule) (SolverRul
 
 		// high priority rule is before low priority rule, then low priority rule is simply shifted
 		if hpRule.From <= lpRule.From {
-			return SolverRules{lpRule.Shift(hpRule.To)}, true
+			return SolverRules{lpRule.Shift(hpRule.To)}, true, nil
 		} else {
 			// high priority rule is after or in middle of low priority rule, then low priority rule is split and shifted
-			return lpRule.Split(hpRule.From, hpRule.To), true
+			return lpRule.Split(hpRule.From, hpRule.To), true, nil
 		}
 
 	// both rules overlap at least slightly, if policy is 'truncate' then truncate overlapping rule
@@ -297,29 +533,29 @@ func (s *Solver) solveVsSingle(lpRule SolverRule, hpRule *SolverRule) (SolverRul
 
 		// high priority rule is partially after low priority rule, then low priority rule end is truncated
 		if hpRule.From >= lpRule.From && hpRule.To >= lpRule.To {
-			return SolverRules{lpRule.TruncateAfter(hpRule.From)}, true
+			return SolverRules{lpRule.TruncateAfter(hpRule.From)}, true, nil
 		}
 
 		// high priority rule is partially before low priority rule, then low priority rule end is truncated
 		if hpRule.From <= lpRule.From && hpRule.To <= lpRule.To {
-			return SolverRules{lpRule.TruncateBefore(hpRule.To)}, true
+			return SolverRules{lpRule.TruncateBefore(hpRule.To)}, true, nil
 		}
 
 		// high priority rule completely overlap low priority rule, then remove the low priority rule
 		if hpRule.From <= lpRule.From && hpRule.To >= lpRule.To {
-			return SolverRules{}, true
+			return SolverRules{}, true, nil
 		}
 
 		// high priority rule is in middle of low priority rule, then low priority rule middle is truncated
 		if hpRule.From >= lpRule.From && hpRule.To <= lpRule.To {
-			return lpRule.TruncateBetween(hpRule.From, hpRule.To), true
+			return lpRule.TruncateBetween(hpRule.From, hpRule.To), true, nil
 		}
 
 	default:
-		panic(fmt.Errorf("unhandled solving policy %v between %v and %v", lpRule.RuleResolutionPolicy, lpRule, hpRule))
+		return nil, false, fmt.Errorf("%w: unhandled solving policy %v between %s and %s", ErrUnsolvableRules, lpRule.RuleResolutionPolicy, lpRule.Name(), hpRule.Name())
 	}
 
-	return SolverRules{}, true
+	return SolverRules{}, true, nil
 }
 
 // buildFixedRulesList builds a list of fixed rules including all fixed rules and activated flatrates
@@ -378,12 +614,21 @@ func (s *Solver) solveShiftableVsFixedRules(lpRule *SolverRule) {
 
 		// Build a list of fixed rules including all fixed rules and activated flatrates
 		fixedRules := s.buildFixedRulesList(lpRule)
+		if s.err != nil {
+			return
+		}
 
 		// Iterate over the previously built fixedrules list and solve the current rule against each of them
 		fixedRules.Ascend(func(hpRule *SolverRule) bool {
 			// Solve the lower priority rule against the higher priority rule
-			ret, changed := s.solveVsSingle(*lpRule, hpRule)
+			ret, changed, err := s.solveVsSingle(*lpRule, hpRule)
 			//fmt.Println(" >> solveVsSingle Result", ret, changed)
+			if err != nil {
+				s.err = err
+				lpRule = nil
+				solved = true
+				return false
+			}
 			solved = !changed
 			if changed {
 				switch len(ret) {
@@ -423,7 +668,12 @@ func (s *Solver) solveAndAppend(lpRule *SolverRule, collection *btree.BTreeG[*So
 	// Loop over all rules in the collection and solve the current rule against each of them
 	collection.Ascend(func(hpRule *SolverRule) bool {
 		//fmt.Println(" >> Solving fixed rule", lpRule.Name(), "vs", hpRule.Name())
-		ret, _ := s.solveVsSingle(*lpRule, hpRule)
+		ret, _, err := s.solveVsSingle(*lpRule, hpRule)
+		if err != nil {
+			s.err = err
+			lpRule = nil
+			return false
+		}
 		switch len(ret) {
 		case 0: // Rule deleted
 			lpRule = nil
@@ -484,12 +734,16 @@ func (s *Solver) findFlatRateActivationTime(flatRateRule *SolverRule, extraRules
 
 	processRule := func(rule *SolverRule) (time.Duration, bool) {
 		r, _ := rule.And(flatRateRule.RelativeTimeSpan)
-		if r != nil {
-			//fmt.Println("    >> processRule", rule.Name(), sumAmount, "+", r.EndAmount, "vs", flatRateRule.ActivationAmount)
-			if sumAmount+r.EndAmount > flatRateRule.ActivationAmount {
-				return r.DurationForAmount(flatRateRule.ActivationAmount - sumAmount), true
+		if r == nil {
+			return 0, false
+		}
+		// The rules with a granularity are charged by steps like in the output
+		for _, step := range r.Steps() {
+			//fmt.Println("    >> processRule", rule.Name(), sumAmount, "+", step.EndAmount, "vs", flatRateRule.ActivationAmount)
+			if sumAmount+step.EndAmount > flatRateRule.ActivationAmount {
+				return step.DurationForAmount(flatRateRule.ActivationAmount - sumAmount), true
 			}
-			sumAmount += r.EndAmount
+			sumAmount += step.EndAmount
 		}
 		return 0, false
 	}
@@ -510,14 +764,40 @@ func (s *Solver) findFlatRateActivationTime(flatRateRule *SolverRule, extraRules
 	return activatedAfter, activated
 }
 
+// ExtractRulesInRange returns the part of the solved rules inside the timespan, the rules with a granularity are
+// expanded in steps and the overlay and retroactive grace rules are applied
 func (s *Solver) ExtractRulesInRange(timespan timeutils.RelativeTimeSpan) SolverRules {
+	out, _ := s.extractRulesInRange(timespan)
+	return out
+}
+
+// deferredCharge is an amount deferred by a retroactive grace period ending with the extracted rules, the
+// rules following them charge it
+type deferredCharge struct {
+	amount Amount
+	grace  SolverRule
+}
+
+// extractRulesInRange is ExtractRulesInRange also returning the amounts deferred by the grace rules which are
+// not charged inside the timespan
+func (s *Solver) extractRulesInRange(timespan timeutils.RelativeTimeSpan) (SolverRules, []deferredCharge) {
 	var out SolverRules
+	var deferred []deferredCharge
 	s.solvedRules.Ascend(func(rule *SolverRule) bool {
 		r, _ := rule.And(timespan)
 		if r != nil {
-			out = append(out, *r)
+			out = append(out, r.Steps()...)
 		}
 		return true
 	})
-	return out
+	for _, overlay := range s.overlayRules {
+		out = out.ApplyOverlay(overlay)
+	}
+	for _, grace := range s.graceRules {
+		var amount Amount
+		if out, amount = out.ApplyGrace(grace); amount > 0 {
+			deferred = append(deferred, deferredCharge{amount, grace})
+		}
+	}
+	return out, deferred
 }
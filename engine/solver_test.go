@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -207,7 +208,10 @@ func TestSolveVsSingle(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			solver := NewSolver()
 			solver.SetWindow(time.Now(), time.Duration(48*time.Hour))
-			out, _ := solver.solveVsSingle(testcase.lpRule, &testcase.hpRule)
+			out, _, err := solver.solveVsSingle(testcase.lpRule, &testcase.hpRule)
+			if err != nil {
+				t.Fatalf("solveVsSingle unexpected error: %v", err)
+			}
 			if len(out) != len(testcase.expected) {
 				t.Errorf("solveVsSingle expected %v rules, got %v", len(testcase.expected), len(out))
 			} else {
@@ -480,7 +484,9 @@ func TestSolver(t *testing.T) {
 			solver.SetWindow(time.Now(), time.Duration(48*time.Hour))
 			solver.AppendMany(testcase.rules...)
 
-			solver.Solve()
+			if err := solver.Solve(); err != nil {
+				t.Fatalf("Solve unexpected error: %v", err)
+			}
 
 			if solver.solvedRules.Len() != len(testcase.expected) {
 				t.Errorf("SolveAndAppend expected %v rules, got %v", len(testcase.expected), solver.solvedRules.Len())
@@ -610,6 +616,327 @@ func TestExtractRange(t *testing.T) {
 	}
 }
 
+func TestSolverRulesApplyQuota(t *testing.T) {
+	// 1h linear, 2h nonpaying, 1h linear
+	rules := SolverRules{
+		NewLinearFixedRule("A", timeutils.RelativeTimeSpan{From: 0, To: time.Hour}, NewAmountFromFloat(1.0), nil),
+		NewNonPayingFixedRule("night", timeutils.RelativeTimeSpan{From: time.Hour, To: 3 * time.Hour}, nil),
+		NewLinearFixedRule("B", timeutils.RelativeTimeSpan{From: 3 * time.Hour, To: 4 * time.Hour}, NewAmountFromFloat(1.0), nil),
+	}
+	period := mustParseRecurrentDate("duration(1d)")
+
+	tests := map[string]struct {
+		quota    Quota
+		used     int
+		expected []time.Duration // end of each remaining rule
+		granted  time.Duration
+	}{
+		"0-DurationAvailable":     {quota: NewDurationQuota("q", 2*time.Hour, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour, 4 * time.Hour}, granted: 2 * time.Hour},
+		"1-DurationPartial":       {quota: NewDurationQuota("q", 90*time.Minute, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour, 3*time.Hour + 30*time.Minute}, granted: 90 * time.Minute},
+		"2-DurationOnlyFirstRule": {quota: NewDurationQuota("q", time.Hour, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour}, granted: time.Hour},
+		"3-DurationExhausted":     {quota: NewDurationQuota("q", 0, period, nil), expected: []time.Duration{}, granted: 0},
+		"4-CounterAvailable":      {quota: NewCounterQuota("q", 1, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour, 4 * time.Hour}, granted: 2 * time.Hour},
+		"5-CounterExhausted":      {quota: NewCounterQuota("q", 1, period, nil), used: 1, expected: []time.Duration{}, granted: 0},
+		"6-AmountAvailable":       {quota: NewAmountQuota("q", NewAmountFromFloat(2.0), NonPayingDuration, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour, 4 * time.Hour}, granted: 4 * time.Hour},
+		"7-AmountPartial":         {quota: NewAmountQuota("q", NewAmountFromFloat(1.5), NonPayingDuration, period, nil), expected: []time.Duration{time.Hour, 3 * time.Hour, 3*time.Hour + 30*time.Minute, 4 * time.Hour}, granted: 3*time.Hour + 30*time.Minute},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			if counter, ok := testcase.quota.(*CounterQuota); ok {
+				counter.used = testcase.used
+			}
+			out, granted := rules.ApplyQuota(testcase.quota, discardLogger())
+			if granted != testcase.granted {
+				t.Errorf("expected granted duration %v, got %v", testcase.granted, granted)
+			}
+			if len(out) != len(testcase.expected) {
+				t.Fatalf("expected %d rules, got %v", len(testcase.expected), out)
+			}
+			for i, end := range testcase.expected {
+				if out[i].To != end {
+					t.Errorf("rule %d: expected end %v, got %v", i, end, out[i].To)
+				}
+			}
+		})
+	}
+}
+
+func TestSolverRuleFallback(t *testing.T) {
+	// Free fixed rule from 0 to 4h, replaced by 2h at 1.0 per hour where its quota gives nothing
+	rule := NewLinearFixedRule("free", timeutils.RelativeTimeSpan{From: 0, To: 4 * time.Hour}, 0, nil)
+	rule.Otherwise = SolverRules{NewLinearSequentialRule("paid", 2*time.Hour, NewAmountFromFloat(1.0), nil)}
+
+	tests := map[string]struct {
+		parts    SolverRules
+		expected []timeutils.RelativeTimeSpan
+		amounts  []Amount
+	}{
+		"0-FullyGiven":    {parts: SolverRules{rule}, expected: []timeutils.RelativeTimeSpan{}},
+		"1-NothingGiven":  {parts: SolverRules{}, expected: []timeutils.RelativeTimeSpan{{From: 0, To: 2 * time.Hour}}, amounts: []Amount{NewAmountFromFloat(2.0)}},
+		"2-GivenAtTheEnd": {parts: SolverRules{rule.TruncateBefore(3 * time.Hour)}, expected: []timeutils.RelativeTimeSpan{{From: 0, To: 2 * time.Hour}}, amounts: []Amount{NewAmountFromFloat(2.0)}},
+		"3-SplitAround": {
+			parts:    SolverRules{rule.TruncateAfter(2 * time.Hour).TruncateBefore(time.Hour)},
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: time.Hour}, {From: 2 * time.Hour, To: 3 * time.Hour}},
+			amounts:  []Amount{NewAmountFromFloat(1.0), NewAmountFromFloat(1.0)},
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := rule.Fallback(testcase.parts)
+			if len(out) != len(testcase.expected) {
+				t.Fatalf("expected %d rules, got %v", len(testcase.expected), out)
+			}
+			for i, span := range testcase.expected {
+				if out[i].RelativeTimeSpan != span || out[i].EndAmount != testcase.amounts[i] {
+					t.Errorf("rule %d: expected %v for %s, got %v for %s", i, span, testcase.amounts[i], out[i].RelativeTimeSpan, out[i].EndAmount)
+				}
+				if out[i].StartTimePolicy != FixedPolicy {
+					t.Errorf("rule %d: expected the fixed policy of the replaced rule", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSolverRuleSteps(t *testing.T) {
+	// Linear rule of 1h at 2.0 per hour charged by units of 15 min
+	rule := NewLinearSequentialRule("hourly", time.Hour, NewAmountFromFloat(2.0), nil)
+	step := NewAmountFromFloat(0.5)
+
+	tests := map[string]struct {
+		rounding Rounding
+		parts    func(rule SolverRule) SolverRules
+		expected []timeutils.RelativeTimeSpan
+		amounts  []Amount
+	}{
+		"0-Up": {
+			rounding: RoundUp,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 15 * time.Minute}, {From: 15 * time.Minute, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 45 * time.Minute}, {From: 45 * time.Minute, To: time.Hour}},
+			amounts:  []Amount{step, step, step, step},
+		},
+		"1-Down": {
+			rounding: RoundDown,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 899 * time.Second}, {From: 899 * time.Second, To: 1799 * time.Second}, {From: 1799 * time.Second, To: 2699 * time.Second}, {From: 2699 * time.Second, To: 3599 * time.Second}, {From: 3599 * time.Second, To: time.Hour}},
+			amounts:  []Amount{0, step, step, step, step},
+		},
+		"2-Nearest": {
+			rounding: RoundNearest,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 449 * time.Second}, {From: 449 * time.Second, To: 1349 * time.Second}, {From: 1349 * time.Second, To: 2249 * time.Second}, {From: 2249 * time.Second, To: 3149 * time.Second}, {From: 3149 * time.Second, To: time.Hour}},
+			amounts:  []Amount{0, step, step, step, step},
+		},
+		"3-SplitUp": {
+			rounding: RoundUp,
+			parts:    func(rule SolverRule) SolverRules { return rule.Split(20*time.Minute, 40*time.Minute) },
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 15 * time.Minute}, {From: 15 * time.Minute, To: 20 * time.Minute}, {From: 40 * time.Minute, To: 50 * time.Minute}, {From: 50 * time.Minute, To: 65 * time.Minute}, {From: 65 * time.Minute, To: 80 * time.Minute}},
+			amounts:  []Amount{step, step, 0, step, step},
+		},
+		"4-TruncatedBeforeDown": {
+			rounding: RoundDown,
+			parts:    func(rule SolverRule) SolverRules { return SolverRules{rule.TruncateBefore(20 * time.Minute)} },
+			expected: []timeutils.RelativeTimeSpan{{From: 20 * time.Minute, To: 1799 * time.Second}, {From: 1799 * time.Second, To: 2699 * time.Second}, {From: 2699 * time.Second, To: 3599 * time.Second}, {From: 3599 * time.Second, To: time.Hour}},
+			amounts:  []Amount{0, step, step, step},
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			granular := rule.WithGranularity(15*time.Minute, testcase.rounding)
+			parts := SolverRules{granular}
+			if testcase.parts != nil {
+				parts = testcase.parts(granular)
+			}
+			var out SolverRules
+			for _, part := range parts {
+				out = append(out, part.Steps()...)
+			}
+			if len(out) != len(testcase.expected) {
+				t.Fatalf("expected %d rules, got %v", len(testcase.expected), out)
+			}
+			for i, span := range testcase.expected {
+				if out[i].RelativeTimeSpan != span || out[i].EndAmount != testcase.amounts[i] || !out[i].IsFlatRate() {
+					t.Errorf("rule %d: expected step %v for %s, got %v", i, span, testcase.amounts[i], out[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSolverRuleTruncateAfterAmountSteps(t *testing.T) {
+	rule := NewLinearSequentialRule("hourly", time.Hour, NewAmountFromFloat(2.0), nil).WithGranularity(15*time.Minute, RoundUp)
+	// 1.2 pays two units of 0.5, the rule stops before the third unit
+	truncated := rule.TruncateAfterAmount(NewAmountFromFloat(1.2))
+	if truncated.To != 30*time.Minute {
+		t.Errorf("expected the rule truncated after 30m, got %s", truncated.To)
+	}
+	if amount, _ := truncated.Steps().SumAll(); amount != NewAmountFromFloat(1.0) {
+		t.Errorf("expected 1.0 charged by the truncated rule, got %s", amount)
+	}
+}
+
+func TestSolverRulesApplyGrace(t *testing.T) {
+	// 30 min step of 0.50 followed by 1h at 2.0 per hour
+	rules := SolverRules{
+		NewFixedRateSequentialRule("first", 30*time.Minute, mustParseAmount("0.50"), nil),
+		NewLinearSequentialRule("hourly", time.Hour, NewAmountFromFloat(2.0), nil).Shift(30 * time.Minute),
+	}
+	banned := SolverRules{
+		NewLinearSequentialRule("hourly", 10*time.Minute, NewAmountFromFloat(2.0), nil),
+		NewBannedFixedRule("market", timeutils.RelativeTimeSpan{From: 10 * time.Minute, To: time.Hour}, nil),
+	}
+
+	tests := map[string]struct {
+		rules    SolverRules
+		grace    time.Duration
+		expected []timeutils.RelativeTimeSpan
+		amounts  []Amount
+		deferred Amount
+	}{
+		"0-StepGoingPastTheGrace": {
+			rules:    rules,
+			grace:    10 * time.Minute,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 10 * time.Minute}, {From: 10 * time.Minute, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 90 * time.Minute}},
+			amounts:  []Amount{0, mustParseAmount("0.50"), NewAmountFromFloat(2.0)},
+		},
+		"1-LinearGoingPastTheGrace": {
+			rules:    rules,
+			grace:    40 * time.Minute,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 40 * time.Minute}, {From: 40 * time.Minute, To: 40*time.Minute + time.Second}, {From: 40*time.Minute + time.Second, To: 90 * time.Minute}},
+			amounts:  []Amount{0, 0, mustParseAmount("0.833889"), mustParseAmount("1.666111")},
+		},
+		"2-BannedAfterTheGrace": {
+			rules:    banned,
+			grace:    10 * time.Minute,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 10 * time.Minute}, {From: 10 * time.Minute, To: time.Hour}},
+			amounts:  []Amount{0, 0},
+		},
+		"3-RulesEndingWithTheGrace": {
+			rules:    rules,
+			grace:    90 * time.Minute,
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 90 * time.Minute}},
+			amounts:  []Amount{0, 0},
+			deferred: mustParseAmount("2.50"),
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, deferred := testcase.rules.ApplyGrace(NewGraceRule("grace", testcase.grace, true, nil))
+			if deferred != testcase.deferred {
+				t.Errorf("expected %s still deferred, got %s", testcase.deferred, deferred)
+			}
+			if len(out) != len(testcase.expected) {
+				t.Fatalf("expected %d rules, got %v", len(testcase.expected), out)
+			}
+			for i, span := range testcase.expected {
+				if out[i].RelativeTimeSpan != span || out[i].EndAmount != testcase.amounts[i] {
+					t.Errorf("rule %d: expected %v for %s, got %v", i, span, testcase.amounts[i], out[i])
+				}
+			}
+			// The whole table costs the same as without grace, unless the parking cannot go past the grace
+			if amount, _ := out.SumAll(); name != "2-BannedAfterTheGrace" && amount+deferred != mustParseAmount("2.50") {
+				t.Errorf("expected 2.50 for the whole rules, got %s", amount)
+			}
+		})
+	}
+}
+
+func TestSolverRulesApplyOverlay(t *testing.T) {
+	// 30 min step of 0.50 followed by 1h at 2.0 per hour, a banned hour and a free hour
+	rules := SolverRules{
+		NewFixedRateSequentialRule("first", 30*time.Minute, mustParseAmount("0.50"), nil),
+		NewLinearSequentialRule("hourly", time.Hour, NewAmountFromFloat(2.0), nil).Shift(30 * time.Minute),
+		NewBannedFixedRule("market", timeutils.RelativeTimeSpan{From: 90 * time.Minute, To: 150 * time.Minute}, nil),
+		NewNonPayingFixedRule("night", timeutils.RelativeTimeSpan{From: 150 * time.Minute, To: 210 * time.Minute}, nil),
+	}
+	overlapped := timeutils.RelativeTimeSpan{From: 0, To: 4 * time.Hour}
+
+	tests := map[string]struct {
+		timespan   timeutils.RelativeTimeSpan
+		factor     Amount
+		hourlyRate Amount
+		expected   []timeutils.RelativeTimeSpan
+		amounts    []Amount
+	}{
+		"0-FactorSplittingTheLinear": {
+			timespan: timeutils.RelativeTimeSpan{From: time.Hour, To: 4 * time.Hour},
+			factor:   mustParseAmount("1.2"),
+			expected: []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: time.Hour}, {From: time.Hour, To: 90 * time.Minute}},
+			amounts:  []Amount{mustParseAmount("0.50"), NewAmountFromFloat(1.0), mustParseAmount("1.20")},
+		},
+		"1-HourlyRateOnTheStep": {
+			timespan:   overlapped,
+			factor:     AmountUnit,
+			hourlyRate: NewAmountFromFloat(1.0),
+			expected:   []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 90 * time.Minute}},
+			amounts:    []Amount{NewAmountFromFloat(1.0), NewAmountFromFloat(3.0)},
+		},
+		"2-DiscountMakingFree": {
+			timespan:   overlapped,
+			factor:     AmountUnit,
+			hourlyRate: NewAmountFromFloat(-3.0),
+			expected:   []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 90 * time.Minute}},
+			amounts:    []Amount{0, 0},
+		},
+		"3-StepBeginningBeforeTheOverlay": {
+			timespan:   timeutils.RelativeTimeSpan{From: 15 * time.Minute, To: 4 * time.Hour},
+			factor:     AmountUnit,
+			hourlyRate: NewAmountFromFloat(1.0),
+			expected:   []timeutils.RelativeTimeSpan{{From: 0, To: 30 * time.Minute}, {From: 30 * time.Minute, To: 90 * time.Minute}},
+			amounts:    []Amount{mustParseAmount("0.50"), NewAmountFromFloat(3.0)},
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			overlay := NewOverlayRule("overlay", testcase.timespan, testcase.factor, testcase.hourlyRate, nil)
+			out := rules.ApplyOverlay(overlay)
+			for i, span := range testcase.expected {
+				if out[i].RelativeTimeSpan != span || out[i].EndAmount != testcase.amounts[i] {
+					t.Errorf("rule %d: expected %v for %s, got %v", i, span, testcase.amounts[i], out[i])
+				}
+			}
+			// The banned and free rules are never changed
+			if banned, free := out[len(out)-2], out[len(out)-1]; banned.DurationType != BannedDuration || free.DurationType != NonPayingDuration {
+				t.Errorf("expected the banned and free rules unchanged, got %v", out)
+			}
+		})
+	}
+	// The rules overlaid are not changed
+	if rules[1].EndAmount != NewAmountFromFloat(2.0) || len(rules[1].Trace) != 1 {
+		t.Errorf("expected the rules unchanged, got %v", rules[1])
+	}
+}
+
+func TestLimitsUsageRemaining(t *testing.T) {
+	limits := TariffLimits{MaxAmount: NewAmountFromFloat(5.0), MaxDuration: 2 * time.Hour}
+	from := 24 * time.Hour
+
+	tests := map[string]struct {
+		usage    limitsUsage
+		expected TariffLimits
+		ok       bool
+	}{
+		"0-Unused":          {usage: limitsUsage{}, expected: TariffLimits{MaxAmount: NewAmountFromFloat(5.0), MaxDuration: from + 2*time.Hour}, ok: true},
+		"1-PartiallyUsed":   {usage: limitsUsage{amount: NewAmountFromFloat(2.0), duration: 30 * time.Minute}, expected: TariffLimits{MaxAmount: NewAmountFromFloat(3.0), MaxDuration: from + 90*time.Minute}, ok: true},
+		"2-AmountReached":   {usage: limitsUsage{amount: NewAmountFromFloat(5.0), duration: 30 * time.Minute}, ok: false},
+		"3-DurationReached": {usage: limitsUsage{amount: NewAmountFromFloat(2.0), duration: 2 * time.Hour}, ok: false},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			remaining, ok := testcase.usage.remaining(limits, from)
+			if ok != testcase.ok {
+				t.Fatalf("expected ok %t, got %t", testcase.ok, ok)
+			}
+			if ok && remaining != testcase.expected {
+				t.Errorf("expected limits %v, got %v", testcase.expected, remaining)
+			}
+		})
+	}
+}
+
 func TestFindFlatRateActivationTime(t *testing.T) {
 	tests := map[string]struct {
 		flatRateRule         SolverRule
@@ -724,3 +1051,36 @@ func TestFindFlatRateActivationTime(t *testing.T) {
 		})
 	}
 }
+
+func TestSolverErrors(t *testing.T) {
+	tests := map[string]struct {
+		rules    SolverRules
+		expected error
+	}{
+		"0-InvalidTimespan": {
+			rules: SolverRules{
+				NewLinearFixedRule("A", timeutils.RelativeTimeSpan{From: 2 * time.Hour, To: 1 * time.Hour}, AmountUnit, MetaData{}),
+			},
+			expected: ErrInvalidTimespan,
+		},
+		"1-UnhandledPolicy": {
+			rules: SolverRules{
+				NewLinearFixedRule("A", timeutils.RelativeTimeSpan{From: 0, To: 2 * time.Hour}, AmountUnit, MetaData{}),
+				{RuleName: "B", RelativeTimeSpan: timeutils.RelativeTimeSpan{From: 1 * time.Hour, To: 3 * time.Hour}, StartTimePolicy: FixedPolicy, RuleResolutionPolicy: "unknown"},
+			},
+			expected: ErrUnsolvableRules,
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			solver := NewSolver()
+			solver.SetWindow(time.Now(), time.Duration(48*time.Hour))
+			solver.AppendMany(testcase.rules...)
+
+			if err := solver.Solve(); !errors.Is(err, testcase.expected) {
+				t.Errorf("Solve expected error %v, got %v", testcase.expected, err)
+			}
+		})
+	}
+}
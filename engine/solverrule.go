@@ -2,10 +2,11 @@ package engine
 
 import (
 	"fmt"
+	"log/slog"
 	"math"
+	"slices"
 	"time"
 
-	"github.com/iem-rd/quote-engine/table"
 	"github.com/iem-rd/quote-engine/timeutils"
 )
 
@@ -67,6 +68,40 @@ func (dt DurationType) String() string {
 	return "unknown"
 }
 
+// Rounding defines when a unit of a linear rule with a granularity is charged
+type Rounding int
+
+const (
+	RoundUp      Rounding = iota // each started unit is charged
+	RoundDown                    // only the completed units are charged
+	RoundNearest                 // a unit is charged once half of it is used
+)
+
+func (r Rounding) MarshalText() ([]byte, error) {
+	conv := map[Rounding]string{
+		RoundUp:      "up",
+		RoundDown:    "down",
+		RoundNearest: "nearest",
+	}
+	if val, ok := conv[r]; ok {
+		return []byte(val), nil
+	}
+	return nil, fmt.Errorf("unknown rounding %d", r)
+}
+
+func (r *Rounding) UnmarshalText(text []byte) error {
+	conv := map[string]Rounding{
+		"up":      RoundUp,
+		"down":    RoundDown,
+		"nearest": RoundNearest,
+	}
+	if val, ok := conv[string(text)]; ok {
+		*r = val
+		return nil
+	}
+	return fmt.Errorf("unknown rounding %s", text)
+}
+
 // StartTimePolicy defines the policy used to move or not the beginning of the rule
 type StartTimePolicy string // Todo replace by int32
 
@@ -110,6 +145,27 @@ type SolverRule struct {
 	DurationType DurationType
 	// Quota is the optional quota associated with the rule.
 	Quota Quota
+	// Otherwise are the rules replacing the rule for the time its quota could not give.
+	Otherwise SolverRules
+	// Granularity is the billing unit of a linear rule, the rule is charged by steps of StepAmount (see Steps)
+	Granularity time.Duration
+	// Rounding defines when each unit of the granularity is charged
+	Rounding Rounding
+	// StepAmount is the amount charged for each unit of the granularity
+	StepAmount Amount
+	// Elapsed is the duration of the original rule before this part, the units stay aligned on the original rule
+	Elapsed time.Duration
+	// Retroactive is set on a grace rule whose period is charged once the parking goes past it (see ApplyGrace)
+	Retroactive bool
+	// Overlay is set on an overlay rule, it changes the amounts of the rules under it (see ApplyOverlay)
+	Overlay *Overlay
+}
+
+// Overlay changes the amounts of the paying rules, they are multiplied by Factor (in currency units, 1.2 is +20%)
+// then HourlyRate is added for the time overlapped
+type Overlay struct {
+	Factor     Amount
+	HourlyRate Amount
 }
 
 // Define a collection of solver rule
@@ -130,7 +186,7 @@ func NewLinearSequentialRule(name string, duration time.Duration, hourlyRate Amo
 		Meta:                 meta,
 		RelativeTimeSpan:     timeutils.RelativeTimeSpan{From: time.Duration(0), To: duration},
 		StartAmount:          0,
-		EndAmount:            Amount(float64(hourlyRate) * duration.Hours()),
+		EndAmount:            hourlyRate.MulDuration(duration, time.Hour),
 		StartTimePolicy:      ShiftablePolicy,
 		RuleResolutionPolicy: ResolvePolicy,
 		DurationType:         DurationTypeFromAmount(hourlyRate),
@@ -151,24 +207,18 @@ func NewFixedRateSequentialRule(name string, duration time.Duration, amount Amou
 }
 
 func NewLinearFixedRule(name string, timespan timeutils.RelativeTimeSpan, hourlyRate Amount, meta MetaData) SolverRule {
-	if !timespan.IsValid() {
-		panic(fmt.Errorf("invalid rule timespan %v", timespan))
-	}
 	return SolverRule{
 		RuleName:             name,
 		Meta:                 meta,
 		RelativeTimeSpan:     timespan,
 		StartAmount:          0,
-		EndAmount:            Amount(float64(hourlyRate) * timespan.Duration().Hours()),
+		EndAmount:            hourlyRate.MulDuration(timespan.Duration(), time.Hour),
 		StartTimePolicy:      FixedPolicy,
 		RuleResolutionPolicy: TruncatePolicy,
 		DurationType:         DurationTypeFromAmount(hourlyRate),
 	}
 }
 func NewFixedRateFixedRule(name string, timespan timeutils.RelativeTimeSpan, amount Amount, meta MetaData) SolverRule {
-	if !timespan.IsValid() {
-		panic(fmt.Errorf("invalid rule timespan %v", timespan))
-	}
 	return SolverRule{
 		RuleName:             name,
 		Meta:                 meta,
@@ -182,9 +232,6 @@ func NewFixedRateFixedRule(name string, timespan timeutils.RelativeTimeSpan, amo
 }
 
 func NewFlatRateFixedRule(name string, timespan timeutils.RelativeTimeSpan, amount Amount, meta MetaData) SolverRule {
-	if !timespan.IsValid() {
-		panic(fmt.Errorf("invalid rule timespan %v", timespan))
-	}
 	return SolverRule{
 		RuleName:             name,
 		Meta:                 meta,
@@ -204,6 +251,40 @@ func NewNonPayingFixedRule(name string, timespan timeutils.RelativeTimeSpan, met
 	return r
 }
 
+// NewBannedFixedRule creates a rule forbidding parking during the timespan
+func NewBannedFixedRule(name string, timespan timeutils.RelativeTimeSpan, meta MetaData) SolverRule {
+	r := NewFlatRateFixedRule(name, timespan, 0, meta)
+	r.DurationType = BannedDuration
+	return r
+}
+
+// NewGraceRule creates a free rule at the beginning of the parking, it is fixed so it takes the place of the paying
+// rules and the sequential rules start after it. A retroactive grace rule takes no time of its own, it makes the
+// rules under it free as long as the parking ends within it (see ApplyGrace).
+func NewGraceRule(name string, duration time.Duration, retroactive bool, meta MetaData) SolverRule {
+	r := NewFlatRateFixedRule(name, timeutils.RelativeTimeSpan{From: 0, To: duration}, 0, meta)
+	r.Retroactive = retroactive
+	return r
+}
+
+// NewOverlayRule creates a rule changing the amounts of the rules under the timespan, it takes no time of its own
+func NewOverlayRule(name string, timespan timeutils.RelativeTimeSpan, factor Amount, hourlyRate Amount, meta MetaData) SolverRule {
+	r := NewFlatRateFixedRule(name, timespan, 0, meta)
+	r.Overlay = &Overlay{Factor: factor, HourlyRate: hourlyRate}
+	return r
+}
+
+// WithGranularity charges the linear rule by units of the granularity instead of per second
+func (rule SolverRule) WithGranularity(granularity time.Duration, rounding Rounding) SolverRule {
+	if granularity <= 0 || rule.IsFlatRate() || rule.Duration() == 0 {
+		return rule
+	}
+	rule.Granularity = granularity
+	rule.Rounding = rounding
+	rule.StepAmount = (rule.EndAmount - rule.StartAmount).MulDuration(granularity, rule.Duration())
+	return rule
+}
+
 func (rule SolverRule) Duration() time.Duration {
 	return rule.To - rule.From
 }
@@ -243,7 +324,7 @@ type TariffLimits struct {
 }
 
 func (limits TariffLimits) String() string {
-	return fmt.Sprintf("MaxAmount %f, MaxDuration %s", limits.MaxAmount, limits.MaxDuration)
+	return fmt.Sprintf("MaxAmount %s, MaxDuration %s", limits.MaxAmount, limits.MaxDuration)
 }
 
 func (limits *TariffLimits) AddOffset(offsetAmout Amount, offsetDuration time.Duration) {
@@ -255,27 +336,25 @@ func (limits *TariffLimits) AddOffset(offsetAmout Amount, offsetDuration time.Du
 	}
 }
 
-func (rules SolverRules) ApplyLimits(limits TariffLimits) SolverRules {
+func (rules SolverRules) ApplyLimits(limits TariffLimits, logger *slog.Logger) SolverRules {
 	if limits.MaxAmount == 0 && limits.MaxDuration == 0 {
 		return rules
 	}
 
-	fmt.Println(" >> Applying limits to", len(rules), "rules", limits)
+	logger.Debug("apply limits", "rules", len(rules), "limits", limits)
 	sumAmount := Amount(0)
 	overflow := false
 	out := SolverRules{}
 	for _, rule := range rules {
 
-		fmt.Println("   >> Rule", rule, sumAmount)
-
-		// check max duration limit
-		if rule.DurationType != NonPayingDuration && limits.MaxDuration > 0 {
+		// check max duration limit, the nonpaying and banned rules are kept whole
+		if rule.DurationType != NonPayingDuration && rule.DurationType != BannedDuration && limits.MaxDuration > 0 {
 			if rule.From > limits.MaxDuration {
-				fmt.Println("   >> maxDuration reached, rule skipped", rule)
+				logger.Debug("max duration reached, rule skipped", "rule", rule.Name(), "from", rule.From)
 				overflow = true
 			} else if rule.To > limits.MaxDuration {
 				rule = rule.TruncateAfter(limits.MaxDuration)
-				fmt.Println("   >> maxDuration reached, rule truncated", rule)
+				logger.Debug("max duration reached, rule truncated", "rule", rule.Name(), "to", rule.To)
 				overflow = true
 			}
 		}
@@ -284,7 +363,7 @@ func (rules SolverRules) ApplyLimits(limits TariffLimits) SolverRules {
 		if limits.MaxAmount > 0 {
 			if sumAmount+rule.EndAmount > limits.MaxAmount {
 				rule = rule.TruncateAfterAmount(limits.MaxAmount - sumAmount)
-				fmt.Println("   >> maxAmount reached, rule truncated", rule)
+				logger.Debug("max amount reached, rule truncated", "rule", rule.Name(), "to", rule.To, "amount", rule.EndAmount)
 				overflow = true
 			}
 		}
@@ -299,33 +378,381 @@ func (rules SolverRules) ApplyLimits(limits TariffLimits) SolverRules {
 	return out
 }
 
-func (rules *SolverRules) GenerateOutput(now time.Time, detailed bool) Output {
+// WithQuota marks the rule as using the quota, unless it already uses the quota of its own
+func (rule SolverRule) WithQuota(quota Quota) SolverRule {
+	if rule.Quota == nil {
+		rule.Quota = quota
+	}
+	return rule
+}
+
+// ApplyQuota truncates the rules once the quota is used up, the nonpaying and banned rules don't use the quota.
+// The whole duration of the rules is requested at once, so a counter quota is used only once for all the rules.
+// The duration given by the quota is returned with the truncated rules, marked as using the quota.
+func (rules SolverRules) ApplyQuota(quota Quota, logger *slog.Logger) (SolverRules, time.Duration) {
+	if amountQuota, ok := quota.(*AmountQuota); ok {
+		return rules.applyAmountQuota(amountQuota, logger)
+	}
+	if durationQuota, ok := quota.(*DurationQuota); ok && durationQuota.Rolling > 0 {
+		return rules.applyRollingQuota(durationQuota, logger)
+	}
+
+	requested := time.Duration(0)
+	for _, rule := range rules {
+		if rule.DurationType != NonPayingDuration && rule.DurationType != BannedDuration {
+			requested += rule.Duration()
+		}
+	}
+	if requested == 0 {
+		return rules, 0
+	}
+
+	granted := quota.UseDuration(requested)
+	logger.Debug("apply quota", "quota", quota.GetName(), "requested", requested, "granted", granted)
+	available := granted
+	out := make(SolverRules, 0, len(rules))
+	for _, rule := range rules {
+		if rule.DurationType == NonPayingDuration || rule.DurationType == BannedDuration {
+			out = append(out, rule)
+			continue
+		}
+		if available <= 0 {
+			logger.Debug("quota is exhausted, rules truncated", "quota", quota.GetName(), "at", rule.From)
+			break
+		}
+		if rule.Duration() > available {
+			rule = rule.TruncateAfter(rule.From + available)
+			rule.Trace = append(rule.Trace, fmt.Sprintf("quota %s exhausted", quota.GetName()))
+		}
+		available -= rule.Duration()
+		out = append(out, rule.WithQuota(quota))
+	}
+	return out, granted
+}
+
+// applyQuotaFrom is ApplyQuota for the part of the rules after from, the rules before it were already given by a
+// previous tariff version and do not use the quota again
+func (rules SolverRules) applyQuotaFrom(quota Quota, from time.Duration, logger *slog.Logger) (SolverRules, time.Duration) {
+	split := slices.IndexFunc(rules, func(rule SolverRule) bool { return rule.To > from })
+	if split < 0 {
+		return rules, 0
+	}
+	before := slices.Clone(rules[:split])
+	after := rules[split:]
+	if first := after[0]; first.From < from {
+		first.Trace = slices.Clip(first.Trace)
+		before = append(before, first.TruncateAfter(from))
+		after = append(SolverRules{first.TruncateBefore(from)}, after[1:]...)
+	}
+	after, granted := after.ApplyQuota(quota, logger)
+	return append(before, after...), granted
+}
+
+// applyRollingQuota keeps the parts of the rules where the rolling quota is available, the allowance freed later
+// in the window is used by the following rules. The duration granted is returned with the remaining rules.
+func (rules SolverRules) applyRollingQuota(quota *DurationQuota, logger *slog.Logger) (SolverRules, time.Duration) {
+	out := make(SolverRules, 0, len(rules))
+	granted := time.Duration(0)
+	for _, rule := range rules {
+		if rule.DurationType == NonPayingDuration || rule.DurationType == BannedDuration {
+			out = append(out, rule)
+			continue
+		}
+		spans := quota.UseTimeSpan(rule.RelativeTimeSpan, false)
+		logger.Debug("apply rolling quota", "quota", quota.GetName(), "rule", rule.Name(), "granted", spans)
+		for _, span := range spans {
+			granted += span.Duration()
+		}
+		for _, part := range rule.ExtractTimeSpans(spans, fmt.Sprintf("quota %s used up", quota.GetName())) {
+			out = append(out, part.WithQuota(quota))
+		}
+	}
+	return out, granted
+}
+
+// ExtractTimeSpans returns the parts of the rule inside the sorted timespans, the reason is traced on the parts of
+// a cut rule
+func (rule SolverRule) ExtractTimeSpans(spans []timeutils.RelativeTimeSpan, reason string) SolverRules {
+	out := make(SolverRules, 0, len(spans))
+	rule.Trace = slices.Clip(rule.Trace)
+	for _, span := range spans {
+		if span == rule.RelativeTimeSpan {
+			out = append(out, rule)
+			continue
+		}
+		part := rule
+		if span.To < rule.To {
+			part = part.TruncateAfter(span.To)
+		}
+		if span.From > rule.From {
+			part = part.TruncateBefore(span.From)
+		}
+		part.Trace = append(part.Trace, reason)
+		out = append(out, part)
+	}
+	return out
+}
+
+// applyAmountQuota stops charging the rules once the amount quota is used up, the remaining time is of the quota
+// exceeded type and without amount. The duration charged is returned with the updated rules.
+func (rules SolverRules) applyAmountQuota(quota *AmountQuota, logger *slog.Logger) (SolverRules, time.Duration) {
+	requested, _ := rules.SumAll()
+	available := quota.UseAmount(requested)
+	logger.Debug("apply amount quota", "quota", quota.GetName(), "requested", requested, "granted", available)
+
+	out := make(SolverRules, 0, len(rules))
+	charged := time.Duration(0)
+	for _, rule := range rules {
+		if rule.EndAmount <= available || rule.DurationType == NonPayingDuration || rule.DurationType == BannedDuration {
+			available -= rule.EndAmount
+			charged += rule.Duration()
+			if rule.EndAmount > 0 {
+				rule = rule.WithQuota(quota)
+			}
+			out = append(out, rule)
+			continue
+		}
+
+		// Charge the part of the rule covered by the remaining amount, a step is charged partially
+		rule.Trace = slices.Clip(rule.Trace)
+		if available > 0 {
+			part := rule
+			if rule.IsFlatRate() {
+				part.StartAmount, part.EndAmount = available, available
+				part.Trace = append(rule.Trace, fmt.Sprintf("charged %s up to quota %s", available, quota.GetName()))
+			} else {
+				part = rule.TruncateAfterAmount(available)
+			}
+			if part.Duration() > 0 {
+				charged += part.Duration()
+				out = append(out, part.WithQuota(quota))
+				rule = rule.TruncateBefore(part.To)
+			}
+			available = 0
+		}
+		if rule.Duration() > 0 {
+			logger.Debug("amount quota exceeded, rule not charged", "quota", quota.GetName(), "rule", rule.Name(), "from", rule.From)
+			rule.StartAmount, rule.EndAmount = 0, 0
+			rule.DurationType = quota.Exceeded
+			rule.Trace = append(rule.Trace, fmt.Sprintf("quota %s exceeded", quota.GetName()))
+			out = append(out, rule)
+		}
+	}
+	return out, charged
+}
+
+// ApplyOverlay changes the amounts of the paying rules under the overlay rule, the rules are split at the bounds
+// of the overlay but never moved. A step is changed only if it is charged under the overlay, that is if it begins
+// under it.
+func (rules SolverRules) ApplyOverlay(overlay SolverRule) SolverRules {
+	out := make(SolverRules, 0, len(rules))
+	for _, rule := range rules {
+		if rule.DurationType != PayingDuration || rule.To <= overlay.From || rule.From >= overlay.To {
+			out = append(out, rule)
+			continue
+		}
+		// A step beginning before the overlay is charged before it
+		if rule.From < overlay.From && rule.IsFlatRate() {
+			out = append(out, rule)
+			continue
+		}
+		if rule.From < overlay.From {
+			rule.Trace = slices.Clip(rule.Trace)
+			out = append(out, rule.TruncateAfter(overlay.From))
+			rule = rule.TruncateBefore(overlay.From)
+		}
+		if rule.To > overlay.To {
+			rule.Trace = slices.Clip(rule.Trace)
+			tail := rule.TruncateBefore(overlay.To)
+			out = append(out, rule.TruncateAfter(overlay.To).overlaid(overlay), tail)
+			continue
+		}
+		out = append(out, rule.overlaid(overlay))
+	}
+	return out
+}
+
+func (rule SolverRule) overlaid(overlay SolverRule) SolverRule {
+	before := rule.EndAmount
+	multiply := func(amount Amount) Amount {
+		return Amount(mulDiv(int64(amount), int64(overlay.Overlay.Factor), int64(AmountUnit)))
+	}
+	added := overlay.Overlay.HourlyRate.MulDuration(rule.Duration(), time.Hour)
+	// A step stays a step, it is charged at once with the amount added
+	if rule.IsFlatRate() {
+		rule.StartAmount = multiply(rule.StartAmount) + added
+	} else {
+		rule.StartAmount = multiply(rule.StartAmount)
+	}
+	rule.EndAmount = multiply(rule.EndAmount) + added
+	// A discount makes the rule free at most
+	rule.StartAmount = max(rule.StartAmount, 0)
+	rule.EndAmount = max(rule.EndAmount, 0)
+	rule.DurationType = DurationTypeFromAmount(rule.EndAmount)
+	rule.Trace = append(slices.Clip(rule.Trace), fmt.Sprintf("overlay %s: %s -> %s", overlay.Name(), before, rule.EndAmount))
+	return rule
+}
+
+// ApplyGrace makes the paying rules free during the retroactive grace period and defers the amount they charge in
+// it to the first rule after it, so a parking ending within the grace period is free and a longer parking pays the
+// grace period like without grace. The amount still deferred when the rules end within the grace period is
+// returned, it is charged by the rules following them (see ChargeDeferred).
+func (rules SolverRules) ApplyGrace(grace SolverRule) (SolverRules, Amount) {
+	deferred := Amount(0)
+	out := make(SolverRules, 0, len(rules)+1)
+	for i, rule := range rules {
+		if rule.From >= grace.To {
+			return append(out, rules[i:].ChargeDeferred(deferred, grace)...), 0
+		}
+		if rule.DurationType != PayingDuration {
+			out = append(out, rule)
+			continue
+		}
+		if rule.To <= grace.To {
+			deferred += rule.EndAmount
+			out = append(out, rule.freeForGrace(grace))
+			continue
+		}
+		// The rule goes past the end of the grace period, only its beginning is free
+		rule.Trace = slices.Clip(rule.Trace)
+		head := rule.TruncateAfter(grace.To)
+		deferred += head.EndAmount
+		out = append(out, head.freeForGrace(grace))
+		rest := append(SolverRules{rule.TruncateBefore(grace.To)}, rules[i+1:]...)
+		return append(out, rest.ChargeDeferred(deferred, grace)...), 0
+	}
+	return out, deferred
+}
+
+// ChargeDeferred charges the amount deferred by a grace period ending before the rules on the first of them. The
+// parking cannot go past a banned rule, the grace period is then never charged.
+func (rules SolverRules) ChargeDeferred(amount Amount, grace SolverRule) SolverRules {
+	if amount <= 0 || len(rules) == 0 || rules[0].DurationType == BannedDuration {
+		return rules
+	}
+	return append(rules[0].chargeDeferred(amount, grace), rules[1:]...)
+}
+
+func (rule SolverRule) freeForGrace(grace SolverRule) SolverRule {
+	rule.StartAmount, rule.EndAmount = 0, 0
+	rule.DurationType = FreeDuration
+	rule.Trace = append(slices.Clip(rule.Trace), fmt.Sprintf("free for grace %s", grace.Name()))
+	return rule
+}
+
+// chargeDeferred charges the amount deferred by a grace period at the beginning of the rule, a paying step adds
+// it to its own amount and any other rule charges it with a step on its first second
+func (rule SolverRule) chargeDeferred(amount Amount, grace SolverRule) SolverRules {
+	rule.Trace = slices.Clip(rule.Trace)
+	trace := fmt.Sprintf("charged %s deferred by grace %s", amount, grace.Name())
+	if rule.IsFlatRate() && rule.DurationType == PayingDuration {
+		rule.StartAmount += amount
+		rule.EndAmount += amount
+		rule.Trace = append(rule.Trace, trace)
+		return SolverRules{rule}
+	}
+	step := rule
+	if rule.Duration() > time.Second {
+		step = rule.TruncateAfter(rule.From + time.Second)
+	}
+	step.StartAmount = step.EndAmount + amount
+	step.EndAmount = step.StartAmount
+	step.DurationType = PayingDuration
+	step.Trace = append(slices.Clip(step.Trace), trace)
+	if step.To == rule.To {
+		return SolverRules{step}
+	}
+	return SolverRules{step, rule.TruncateBefore(step.To)}
+}
+
+// GenerateOutput builds the output table from the merged rules, the table ends at the first gap between rules
+func (rules *SolverRules) GenerateOutput(now time.Time, detailed bool, logger *slog.Logger) Output {
+	return rules.GenerateFilledOutput(now, detailed, nil, 0, nil, logger)
+}
+
+// GenerateFilledOutput builds the output table like GenerateOutput, but if fill is set the gaps between rules
+// and after the last rule are filled with segments of this duration type and the table goes on up to the end of
+// the window. The gaps met are reported in the output, named after the sequences which left them.
+func (rules *SolverRules) GenerateFilledOutput(now time.Time, detailed bool, fill *DurationType, window time.Duration, gaps SequenceGaps, logger *slog.Logger) Output {
 	var out Output
 	var previous SolverRule
 
 	out.Now = now
 
-	fmt.Println("Generating output for", len(*rules), "rules")
-	for _, rule := range *rules {
-		fmt.Println("   Rule", rule)
-		// If there is a gap between the previous rule and the current one this is the end of the output
-		if previous.To != rule.From {
-			fmt.Println("   >> Gap detected, end of output", previous, rule)
-			break
+	// Append the rule as the next segment of the table, false is returned if the table ends before the rule
+	appendRule := func(rule SolverRule) bool {
+		// A banned rule is the last segment of the output, the parking is allowed again at its end
+		if previous.DurationType == BannedDuration && rule.DurationType != BannedDuration {
+			logger.Debug("banned rule, end of output", "after", previous.Name(), "at", previous.To)
+			return false
+		}
+		if rule.DurationType == BannedDuration {
+			allowed := now.Add(rule.To)
+			out.NextAllowed = &allowed
 		}
 		seg := OutputSegment{
 			Duration:     int(math.Round(rule.To.Seconds() - previous.To.Seconds())),
-			Amount:       rule.EndAmount.Simplify(),
+			Amount:       rule.EndAmount,
 			Islinear:     !rule.IsFlatRate(),
 			DurationType: rule.DurationType,
 			Meta:         rule.Meta,
 		}
+		if rule.Quota != nil {
+			seg.Quota = rule.Quota.GetName()
+		}
 		if detailed {
 			seg.SegName = rule.Name()
 			seg.Trace = rule.Trace
 		}
 		out.Table = append(out.Table, seg)
 		previous = rule
+		return true
+	}
+
+	// Report the gap between the previous rule and to, false is returned if the table ends before it
+	appendGap := func(to time.Duration) bool {
+		holes := gaps.Cover(timeutils.RelativeTimeSpan{From: previous.To, To: to})
+		out.Gaps = append(out.Gaps, holes.ToOutput(now)...)
+
+		// If there is a gap after the previous rule this is the end of the output...
+		if fill == nil {
+			logger.Debug("gap detected, end of output", "after", previous.Name(), "at", previous.To, "to", to)
+			return false
+		}
+		// ... unless the gaps are filled
+		logger.Debug("gap detected, filled", "after", previous.Name(), "at", previous.To, "to", to, "type", *fill)
+		for _, hole := range holes {
+			if !appendRule(hole.ToSolverRule(*fill)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	logger.Debug("generate output", "rules", len(*rules))
+	for _, rule := range *rules {
+		if previous.To != rule.From && !appendGap(rule.From) {
+			return out
+		}
+		if !appendRule(rule) {
+			return out
+		}
+	}
+	// The table goes on up to the end of the window, unless it ends with a banned rule
+	if previous.To < window && previous.DurationType != BannedDuration {
+		appendGap(window)
+	}
+	return out
+}
+
+// ExtractRulesInRange returns the part of the rules inside the timespan
+func (rules SolverRules) ExtractRulesInRange(timespan timeutils.RelativeTimeSpan) SolverRules {
+	var out SolverRules
+	for i := range rules {
+		r, _ := rules[i].And(timespan)
+		if r != nil {
+			out = append(out, *r)
+		}
 	}
 	return out
 }
@@ -345,63 +772,79 @@ func (rules SolverRules) SumAll() (Amount, time.Duration) {
 	return amountSum, durationSum
 }
 
-// PrintAsTable prints the rules as a table using table view
-func (rules SolverRules) PrintAsTable(title string, now time.Time) {
+// SequenceGap is a part of a sequence validity period where the sequence has no rule
+type SequenceGap struct {
+	timeutils.RelativeTimeSpan
+	Sequence string
+}
+
+// SequenceGaps is a list of gaps sorted by start time
+type SequenceGaps []SequenceGap
 
-	tbl := StartRulesTable(title, now)
+// Gaps returns the parts of the timespan not covered by the rules, the rules must be sorted by start time
+func (rules SolverRules) Gaps(timespan timeutils.RelativeTimeSpan, sequence string) SequenceGaps {
+	var out SequenceGaps
+	at := timespan.From
 	for _, rule := range rules {
-		tbl.AddRule(&rule)
+		if rule.From > at {
+			out = append(out, SequenceGap{timeutils.RelativeTimeSpan{From: at, To: min(rule.From, timespan.To)}, sequence})
+		}
+		at = max(at, rule.To)
+		if at >= timespan.To {
+			return out
+		}
 	}
-	tbl.Print()
-}
-
-type RulesTable struct {
-	now   time.Time
-	tbl   table.Table
-	title string
-	empty bool
+	if at < timespan.To {
+		out = append(out, SequenceGap{timeutils.RelativeTimeSpan{From: at, To: timespan.To}, sequence})
+	}
+	return out
 }
 
-func StartRulesTable(title string, now time.Time) *RulesTable {
-	tbl := table.New("Name", "From", "To", "Duration", "From (abs)", "To (abs)", "StartAmount", "EndAmount", "IsLinear", "ActivAm", "Type")
-	t := RulesTable{
-		now:   now,
-		title: title,
-		tbl:   tbl,
-		empty: true,
+// ExtractInRange returns the part of the gaps inside the timespan
+func (gaps SequenceGaps) ExtractInRange(timespan timeutils.RelativeTimeSpan) SequenceGaps {
+	var out SequenceGaps
+	for _, gap := range gaps {
+		from, to := max(gap.From, timespan.From), min(gap.To, timespan.To)
+		if from < to {
+			out = append(out, SequenceGap{timeutils.RelativeTimeSpan{From: from, To: to}, gap.Sequence})
+		}
 	}
-	table.SetDefaultTheme(&t.tbl)
-	return &t
+	return out
 }
 
-func (t *RulesTable) AddRule(rule *SolverRule) {
-
-	dateToString := func(now time.Time, delta time.Duration) string {
-		if now.IsZero() {
-			return ""
+// Cover returns the gaps covering exactly the timespan, the parts of the timespan outside of the known gaps
+// are returned as gaps without sequence
+func (gaps SequenceGaps) Cover(timespan timeutils.RelativeTimeSpan) SequenceGaps {
+	var out SequenceGaps
+	at := timespan.From
+	for _, gap := range gaps.ExtractInRange(timespan) {
+		if gap.From > at {
+			out = append(out, SequenceGap{timeutils.RelativeTimeSpan{From: at, To: gap.From}, ""})
 		}
-		return now.Add(delta).Format("2006-01-02 15:04:05")
+		out = append(out, gap)
+		at = gap.To
+	}
+	if at < timespan.To {
+		out = append(out, SequenceGap{timeutils.RelativeTimeSpan{From: at, To: timespan.To}, ""})
 	}
+	return out
+}
 
-	t.tbl.AddRow(rule.Name(),
-		rule.From.String(),
-		rule.To.String(),
-		rule.Duration().String(),
-		dateToString(t.now, rule.From),
-		dateToString(t.now, rule.To),
-		rule.StartAmount.String(),
-		rule.EndAmount.String(),
-		fmt.Sprintf("%t", rule.IsFlatRate()),
-		rule.ActivationAmount.String(),
-		rule.DurationType.String(),
-	)
-	t.empty = false
+// ToSolverRule returns a zero amount rule of the given duration type filling the gap
+func (gap SequenceGap) ToSolverRule(durationType DurationType) SolverRule {
+	rule := NewFlatRateFixedRule("gap", gap.RelativeTimeSpan, 0, nil)
+	rule.DurationType = durationType
+	if gap.Sequence != "" {
+		rule.Trace = append(rule.Trace, fmt.Sprintf("gap left by sequence %s", gap.Sequence))
+	}
+	return rule
 }
 
-func (t *RulesTable) Print() {
-	if !t.empty {
-		fmt.Println()
-		table.TitleTheme().Println(t.title)
-		t.tbl.Print()
+// ToOutput converts the gaps to absolute dates from now
+func (gaps SequenceGaps) ToOutput(now time.Time) []OutputGap {
+	out := make([]OutputGap, 0, len(gaps))
+	for _, gap := range gaps {
+		out = append(out, OutputGap{From: now.Add(gap.From), To: now.Add(gap.To), Sequence: gap.Sequence})
 	}
+	return out
 }
@@ -2,20 +2,69 @@ package engine
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
+	"sort"
 	"time"
+
+	"github.com/iem-rd/quote-engine/timeutils"
 )
 
 type TariffDefinition struct {
 	Quotas    QuotaInventory
 	NonPaying AbsoluteNonPayingRules
+	Banned    AbsoluteBannedRules
 	Sequences TariffSequenceInventory
 	Config    TariffConfig
+	// Versions replacing the quotas, nonpaying, banned and sequences above from their effective date, sorted by date
+	Versions []TariffVersion
+}
+
+// TariffVersion holds the sections of a tariff applying from an effective date
+type TariffVersion struct {
+	Effective time.Time
+	Quotas    QuotaInventory
+	NonPaying AbsoluteNonPayingRules
+	Banned    AbsoluteBannedRules
+	Sequences TariffSequenceInventory
+}
+
+// versions returns all the versions of the tariff, the initial one has a zero effective date
+func (td TariffDefinition) versions() []TariffVersion {
+	initial := TariffVersion{Quotas: td.Quotas, NonPaying: td.NonPaying, Banned: td.Banned, Sequences: td.Sequences}
+	return append([]TariffVersion{initial}, td.Versions...)
 }
 
 type TariffConfig struct {
 	// Window of time to consider for the tarif computation
 	Window time.Duration `yaml:"window"`
-	Limits TariffLimits  `yaml:",inline"`
+	// IANA time zone name of the tariff (e.g. Europe/Paris), local time zone if empty
+	Timezone string       `yaml:"timezone"`
+	Limits   TariffLimits `yaml:",inline"`
+	// FillGaps is the duration type (f, np or b) of the segments filling the gaps between rules, the table
+	// ends at the first gap if not set
+	FillGaps *DurationType `yaml:"fillgaps"`
+	// Quota is the name of a quota applying to the whole table, for example an amount quota capping the
+	// spending per period
+	Quota string `yaml:"quota"`
+
+	location *time.Location
+}
+
+// LoadLocation loads the time zone of the tariff, empty name means the local time zone
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Location returns the time zone in which the tariff patterns, dates and output are computed
+func (c TariffConfig) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
 }
 
 func DefaultConfig() TariffConfig {
@@ -24,30 +73,176 @@ func DefaultConfig() TariffConfig {
 	}
 }
 
-func (td TariffDefinition) Compute(now time.Time, history AssignedRights) Output {
+// ComputeOption sets an optional parameter of TariffDefinition.Compute
+type ComputeOption func(*computeOptions)
 
-	now = now.Local().Truncate(time.Second)
-	fmt.Println("Now is", now)
+type computeOptions struct {
+	logger   *slog.Logger
+	dump     *RulesDump
+	profile  CustomerProfile
+	cashStep *Amount
+}
 
-	// Update the quotas depending on the history
-	td.Quotas.Update(now, history)
+// WithLogger sets the logger receiving the computation traces, nothing is logged by default
+func WithLogger(logger *slog.Logger) ComputeOption {
+	return func(o *computeOptions) {
+		o.logger = logger
+	}
+}
 
-	// Solve all sequences
-	td.Sequences.Solve(now, td.Config.Window, td.NonPaying)
+// WithProfile sets the customer profile selecting the sequences and rules with a when condition, by default
+// the customer has no tariff code, layer code nor flags
+func WithProfile(profile CustomerProfile) ComputeOption {
+	return func(o *computeOptions) {
+		o.profile = profile
+	}
+}
 
-	// Merge all sequences together
-	rules, _ := td.Sequences.Merge(now, td.Config.Window) //TODO handle error if needed
+// WithCashStep makes the table for the smallest amount which can be paid, see Output.WithCashStep. Compute fails
+// if the step is not accepted by ValidateCashStep.
+func WithCashStep(step Amount) ComputeOption {
+	return func(o *computeOptions) {
+		o.cashStep = &step
+	}
+}
+
+// WithRulesDump writes the rules tables of each computation step to w, colors are disabled if noColor is set
+func WithRulesDump(w io.Writer, noColor bool) ComputeOption {
+	return func(o *computeOptions) {
+		o.dump = NewRulesDump(w, noColor)
+	}
+}
 
-	rules.PrintAsTable(fmt.Sprintf("Output before applying limits (%d rules):", len(rules)), now)
+// Compute the tariff table for the given time and parking rights history. The tariff definition is
+// not modified, each call works on its own quotas and solvers state so a single parsed tariff can be
+// computed concurrently from several goroutines.
+// The returned error wraps one of ErrInvalidTimespan, ErrRecurrentRule, ErrQuotaMatching, ErrProfileMatching,
+// ErrUnsolvableRules or ErrInvalidCashStep.
+func (td TariffDefinition) Compute(now time.Time, history AssignedRights, options ...ComputeOption) (Output, error) {
+	opts := computeOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.cashStep != nil {
+		if err := ValidateCashStep(*opts.cashStep); err != nil {
+			return Output{}, err
+		}
+	}
+	logger, dump := opts.logger, opts.dump
+	if logger == nil {
+		logger = discardLogger()
+	}
 
-	rules = rules.ApplyLimits(td.Config.Limits)
+	now = now.In(td.Config.Location()).Truncate(time.Second)
+	logger.Debug("compute tariff", "now", now)
+
+	// Use the version in effect at now up to the next effective date, then the versions taking effect inside
+	// the window from their effective date. Each version is solved from now, so the sequential rules go on
+	// across the switch, and is kept only while it is in effect. The quotas are shared by the versions, a
+	// version goes on with the usage left by the previous ones.
+	window := td.Config.Window
+	versions := td.versions()
+	first := sort.Search(len(versions), func(i int) bool { return versions[i].Effective.After(now) }) - 1
+	var rules SolverRules
+	var gaps SequenceGaps
+	quotas := QuotaInventory{}
+	for i := first; i < len(versions); i++ {
+		timespan := timeutils.RelativeTimeSpan{From: 0, To: window}
+		if i > first {
+			timespan.From = versions[i].Effective.Sub(now)
+		}
+		if i+1 < len(versions) && versions[i+1].Effective.Before(now.Add(window)) {
+			timespan.To = versions[i+1].Effective.Sub(now)
+		}
+		if timespan.From >= window {
+			break
+		}
+		if i > first {
+			logger.Debug("switch tariff version", "effective", versions[i].Effective)
+			dump.Title("Tariff version effective from", versions[i].Effective)
+		}
+
+		if err := quotas.Continue(versions[i].Quotas, now, history, logger); err != nil {
+			return Output{}, err
+		}
+		versionRules, versionGaps, err := versions[i].solve(now, timespan, quotas, opts.profile, logger, dump)
+		if err != nil {
+			return Output{}, err
+		}
+		if i > first {
+			versionRules, versionGaps = versionRules.ExtractRulesInRange(timespan), versionGaps.ExtractInRange(timespan)
+		}
+		rules = append(rules, versionRules...)
+		gaps = append(gaps, versionGaps...)
+	}
 
-	rules.PrintAsTable(fmt.Sprintf("Output with limits applied (%d rules):", len(rules)), now)
+	dump.PrintRules(fmt.Sprintf("Output before applying limits (%d rules):", len(rules)), now, rules)
 
-	out := rules.GenerateOutput(now, true)
+	rules = rules.ApplyLimits(td.Config.Limits, logger)
 
+	dump.PrintRules(fmt.Sprintf("Output with limits applied (%d rules):", len(rules)), now, rules)
+
+	// The tariff quota applies only to the customers it matches
+	if quota, exists := quotas[td.Config.Quota]; exists {
+		match, err := quota.MatchProfile(opts.profile, logger)
+		if err != nil {
+			return Output{}, fmt.Errorf("%w for quota %s: %w", ErrProfileMatching, quota.GetName(), err)
+		}
+		if match {
+			rules, _ = rules.ApplyQuota(quota, logger)
+			dump.PrintRules(fmt.Sprintf("Output with quota %s applied (%d rules):", quota.GetName(), len(rules)), now, rules)
+		}
+	}
+
+	out := rules.GenerateFilledOutput(now, true, td.Config.FillGaps, window, gaps, logger)
+	if opts.cashStep != nil {
+		out = out.WithCashStep(*opts.cashStep)
+	}
+
+	// The expiry date depends on the quotas of all the versions
 	_, maxDuration := rules.SumAll()
-	out.ExpiryDate = td.Quotas.GetExpiryDate(now.Add(maxDuration))
+	expiry, err := quotas.GetExpiryDate(now.Add(maxDuration))
+	if err != nil {
+		return Output{}, err
+	}
+	out.ExpiryDate = expiry
 
-	return out
+	out.Quotas, err = quotas.ToOutput(now, out.Table)
+	if err != nil {
+		return Output{}, err
+	}
+
+	logger.Info("tariff computed", "now", now, "segments", len(out.Table), "duration", maxDuration, "expiry", out.ExpiryDate)
+	return out, nil
+}
+
+// solve solves and merges the sequences of the version from now to the end of the timespan where it is in effect,
+// the quotas usage is the one of the computation and only the rules inside the timespan use the quotas
+func (v TariffVersion) solve(now time.Time, timespan timeutils.RelativeTimeSpan, quotas QuotaInventory, profile CustomerProfile, logger *slog.Logger, dump *RulesDump) (SolverRules, SequenceGaps, error) {
+	// Leave out the sequences and rules not applying to the customer
+	sequences, err := v.Sequences.ForProfile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonpaying, err := v.NonPaying.ForProfile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	banned, err := v.Banned.ForProfile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Solve all sequences
+	sequences = sequences.WithNewSolvers(logger, dump)
+	if err := sequences.Solve(now, timespan.To, timespan.From, banned, nonpaying, quotas); err != nil {
+		return nil, nil, err
+	}
+
+	// Merge all sequences together
+	rules, gaps, err := sequences.Merge(now, timespan.To, timespan.From, quotas, logger, dump)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rules, gaps, nil
 }
@@ -0,0 +1,585 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A single parsed tariff must give the same results when computed several times,
+// sequentially or concurrently. Run with -race to detect shared state between calls.
+func TestComputeIsReentrant(t *testing.T) {
+	tariffs := []string{
+		"testdata/devs/durationquota_filtering.yaml",
+		"testdata/devs/counterquota_filtering.yaml",
+	}
+	history, err := LoadHistoryFromFile("testdata/devs/history1.rights")
+	if err != nil {
+		t.Fatalf("failed to load history from file: %v", err)
+	}
+	histories := []AssignedRights{nil, history}
+	nows := []time.Time{
+		time.Date(2025, 3, 17, 6, 0, 0, 0, time.Local),
+		time.Date(2025, 3, 17, 19, 30, 0, 0, time.Local),
+	}
+
+	for _, tariffFile := range tariffs {
+		t.Run(filepath.Base(tariffFile), func(t *testing.T) {
+			tariffDescr, err := os.ReadFile(tariffFile)
+			if err != nil {
+				t.Fatalf("failed to read yaml file: %v", err)
+			}
+
+			// Compute the reference tables, each one from a freshly parsed tariff
+			type request struct {
+				now      time.Time
+				history  AssignedRights
+				expected []byte
+			}
+			var requests []request
+			for _, now := range nows {
+				for _, history := range histories {
+					tariff, err := ParseTariffDefinition(tariffDescr)
+					if err != nil {
+						t.Fatalf("failed to parse tariff definition: %v", err)
+					}
+					out, err := tariff.Compute(now, history)
+					if err != nil {
+						t.Fatalf("failed to compute tariff: %v", err)
+					}
+					expected, err := out.ToJson()
+					if err != nil {
+						t.Fatalf("failed to convert table to JSON: %v", err)
+					}
+					requests = append(requests, request{now, history, expected})
+				}
+			}
+
+			// Compute them again, sequentially then concurrently, with a single shared tariff
+			tariff, err := ParseTariffDefinition(tariffDescr)
+			if err != nil {
+				t.Fatalf("failed to parse tariff definition: %v", err)
+			}
+			check := func(r request) {
+				out, err := tariff.Compute(r.now, r.history)
+				if err != nil {
+					t.Errorf("failed to compute tariff: %v", err)
+					return
+				}
+				json, err := out.ToJson()
+				if err != nil {
+					t.Errorf("failed to convert table to JSON: %v", err)
+					return
+				}
+				if !bytes.Equal(json, r.expected) {
+					t.Errorf("table mismatch for now %v:\ngot %s\nexpected %s", r.now, json, r.expected)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				for _, r := range requests {
+					check(r)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				for _, r := range requests {
+					wg.Add(1)
+					go func(r request) {
+						defer wg.Done()
+						check(r)
+					}(r)
+				}
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	tests := map[string]struct {
+		tariff   string
+		now      time.Time
+		history  AssignedRights
+		expected error
+	}{
+		"0-InvalidMatchingPattern": {
+			tariff: `
+version: "0.1"
+quotas:
+- duration:
+    name: "q1"
+    periodicity: duration(24h)
+    allowance: 2h
+    matching:
+    - tariff: "[t1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "free"
+      quota: "q1"
+      hourlyrate: 0
+      duration: 2h
+`,
+			now:      time.Date(2025, 3, 17, 6, 0, 0, 0, time.Local),
+			history:  AssignedRights{{TariffCode: "t1", StartDate: time.Date(2025, 3, 17, 4, 0, 0, 0, time.Local)}},
+			expected: ErrQuotaMatching,
+		},
+		"1-QuotaPeriodBeforeFirstOccurrence": {
+			tariff: `
+version: "0.1"
+quotas:
+- counter:
+    name: "q1"
+    periodicity: pattern(*/* 00:00)
+    allowance: 2
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: 1.0
+      duration: 2h
+`,
+			now:      time.Date(2019, 3, 17, 6, 0, 0, 0, time.Local),
+			expected: ErrRecurrentRule,
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tariff, err := ParseTariffDefinition([]byte(testcase.tariff))
+			if err != nil {
+				t.Fatalf("failed to parse tariff definition: %v", err)
+			}
+			_, err = tariff.Compute(testcase.now, testcase.history)
+			if !errors.Is(err, testcase.expected) {
+				t.Errorf("expected error %v, got %v", testcase.expected, err)
+			}
+		})
+	}
+}
+
+// An amount quota caps the amount of a sequence or of the whole tariff, a rule cannot use it
+func TestParseAmountQuotaOnRule(t *testing.T) {
+	_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+quotas:
+- amount:
+    name: "day cap"
+    periodicity: pattern(*/* 00:00)
+    allowance: 5.0
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      duration: 24h
+      hourlyrate: 2.0
+      quota: "day cap"
+`))
+	if err == nil || !strings.Contains(err.Error(), "amount quota day cap cannot be used by a rule") {
+		t.Errorf("expected an amount quota error, got %v", err)
+	}
+}
+
+func TestComputeDebugOutputs(t *testing.T) {
+	tariffDescr, err := os.ReadFile("testdata/devs/durationquota_filtering.yaml")
+	if err != nil {
+		t.Fatalf("failed to read yaml file: %v", err)
+	}
+	tariff, err := ParseTariffDefinition(tariffDescr)
+	if err != nil {
+		t.Fatalf("failed to parse tariff definition: %v", err)
+	}
+	history, err := LoadHistoryFromFile("testdata/devs/history1.rights")
+	if err != nil {
+		t.Fatalf("failed to load history from file: %v", err)
+	}
+	now := time.Date(2025, 3, 17, 6, 0, 0, 0, time.Local)
+
+	for _, noColor := range []bool{true, false} {
+		var logs, dump bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		if _, err := tariff.Compute(now, history, WithLogger(logger), WithRulesDump(&dump, noColor)); err != nil {
+			t.Fatalf("failed to compute tariff: %v", err)
+		}
+		if !strings.Contains(logs.String(), `msg="apply quota" rule=minamount`) {
+			t.Errorf("expected quota traces in the logs, got:\n%s", logs.String())
+		}
+		if !strings.Contains(dump.String(), "Output with limits applied (2 rules):") {
+			t.Errorf("expected output rules table in the dump, got:\n%s", dump.String())
+		}
+		if hasColor := strings.Contains(dump.String(), "\x1b["); hasColor == noColor {
+			t.Errorf("expected colors in the dump %v, got %v", !noColor, hasColor)
+		}
+	}
+}
+
+// The night nonpaying span follows the wall clock of the tariff time zone, it is 1 hour shorter when the
+// clocks go forward and 1 hour longer when they go back, whatever the local time zone is
+func TestComputeDSTChangeover(t *testing.T) {
+	tariffDescr := []byte(`
+version: "0.1"
+config:
+  window: 24h
+  timezone: Europe/Paris
+nonpaying:
+- name: "night"
+  start: pattern(*/* 20:00)
+  end: pattern(*/* 08:00)
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      duration: 24h
+      hourlyrate: 1.0
+`)
+	tariff, err := ParseTariffDefinition(tariffDescr)
+	if err != nil {
+		t.Fatalf("failed to parse tariff definition: %v", err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	tests := map[string]struct {
+		now      time.Time
+		expected time.Duration
+	}{
+		"0-RegularNight":  {now: time.Date(2025, 3, 22, 19, 0, 0, 0, paris), expected: 12 * time.Hour},
+		"1-SpringForward": {now: time.Date(2025, 3, 29, 19, 0, 0, 0, paris), expected: 11 * time.Hour},
+		"2-FallBack":      {now: time.Date(2025, 10, 25, 19, 0, 0, 0, paris), expected: 13 * time.Hour},
+		"3-UTCNow":        {now: time.Date(2025, 10, 25, 17, 0, 0, 0, time.UTC), expected: 13 * time.Hour},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := tariff.Compute(testcase.now, nil)
+			if err != nil {
+				t.Fatalf("failed to compute tariff: %v", err)
+			}
+			if out.Now.Location().String() != "Europe/Paris" {
+				t.Errorf("expected output in Europe/Paris, got %s", out.Now.Location())
+			}
+			if len(out.Table) < 2 {
+				t.Fatalf("expected at least 2 segments, got %s", out.Table)
+			}
+			night := out.Table[1]
+			if night.DurationType != NonPayingDuration {
+				t.Fatalf("expected a nonpaying segment, got %s", night)
+			}
+			if d := time.Duration(night.Duration) * time.Second; d != testcase.expected {
+				t.Errorf("expected night of %s, got %s", testcase.expected, d)
+			}
+		})
+	}
+}
+
+func TestParseTariffVersions(t *testing.T) {
+	base := `
+version: "0.1"
+nonpaying:
+- name: "sunday"
+  start: pattern(*/* SUN 00:00)
+  end: pattern(*/* MON 00:00)
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: 1.0
+      duration: 2h
+`
+	tests := map[string]struct {
+		versions string
+		expected string
+	}{
+		"0-InheritedSections": {
+			versions: `
+versions:
+- effective: 2026/01/01 00:00
+  quotas:
+  - counter:
+      name: "q1"
+      periodicity: duration(24h)
+      allowance: 2
+  sequences:
+  - name: "default"
+    quota: "q1"
+    rules: []
+- effective: 2026/02/01 12:30:00
+  nonpaying: []
+`,
+		},
+		"1-InvalidEffectiveDate": {
+			versions: `
+versions:
+- effective: 2026-01-01
+`,
+			expected: "invalid effective date: invalid fixed date format: 2026-01-01",
+		},
+		"2-UnsortedVersions": {
+			versions: `
+versions:
+- effective: 2026/01/01 00:00
+- effective: 2026/01/01 00:00
+`,
+			expected: "versions must be sorted by effective date: 2026/01/01 00:00",
+		},
+		"3-UnknownQuota": {
+			versions: `
+versions:
+- effective: 2026/01/01 00:00
+  sequences:
+  - name: "default"
+    quota: "q1"
+    rules: []
+`,
+			expected: "unknown quota: q1",
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tariff, err := ParseTariffDefinition([]byte(base + testcase.versions))
+			if testcase.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), testcase.expected) {
+					t.Fatalf("expected error %q, got %v", testcase.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse tariff definition: %v", err)
+			}
+
+			if len(tariff.Versions) != 2 {
+				t.Fatalf("expected 2 versions, got %d", len(tariff.Versions))
+			}
+			first, second := tariff.Versions[0], tariff.Versions[1]
+			if !first.Effective.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)) || !second.Effective.Equal(time.Date(2026, 2, 1, 12, 30, 0, 0, time.Local)) {
+				t.Errorf("unexpected effective dates %v, %v", first.Effective, second.Effective)
+			}
+			if len(first.NonPaying) != 1 || len(second.NonPaying) != 0 {
+				t.Errorf("expected nonpaying rules to be inherited then removed, got %d and %d", len(first.NonPaying), len(second.NonPaying))
+			}
+			if len(second.Quotas) != 1 || len(second.Sequences) != 1 || second.Sequences[0].Quota == nil {
+				t.Errorf("expected quotas and sequences to be inherited, got %v", second.Sequences)
+			}
+			if second.Sequences[0].Quota != second.Quotas["q1"] {
+				t.Errorf("expected the inherited sequence to refer to the version quota")
+			}
+		})
+	}
+}
+
+// A tariff split into identical versions gives the same table as the tariff without versions, the sequential
+// rules and the quotas go on across the version switch
+func TestComputeIdenticalVersions(t *testing.T) {
+	base := `
+version: "0.1"
+config:
+  window: 10h
+  timezone: UTC
+quotas:
+- duration:
+    name: "free"
+    periodicity: pattern(*/* 00:00)
+    allowance: 30m
+nonpaying:
+- name: "night"
+  start: pattern(*/* 22:00)
+  end: pattern(*/* 06:00)
+sequences:
+- name: "default"
+  rules:
+  - fixedrate:
+      name: "first hour"
+      duration: 1h
+      amount: 0.50
+  - abslinear:
+      name: "free afternoon"
+      start: pattern(*/* 16:00)
+      end: pattern(*/* 17:00)
+      hourlyrate: 0
+      quota: "free"
+  - linear:
+      name: "hourly"
+      duration: 24h
+      hourlyrate: 2.0
+`
+	sequences := `
+  sequences:
+  - name: "default"
+    rules:
+    - fixedrate:
+        name: "first hour"
+        duration: 1h
+        amount: 0.50
+    - abslinear:
+        name: "free afternoon"
+        start: pattern(*/* 16:00)
+        end: pattern(*/* 17:00)
+        hourlyrate: 0
+        quota: "free"
+    - linear:
+        name: "hourly"
+        duration: 24h
+        hourlyrate: 2.0
+`
+	nonpaying := `
+  nonpaying:
+  - name: "night"
+    start: pattern(*/* 22:00)
+    end: pattern(*/* 06:00)
+`
+	tests := map[string]struct {
+		versions string
+	}{
+		"0-SameSequences":      {versions: "\nversions:\n- effective: 2025/03/17 15:30" + sequences},
+		"1-InheritedSequences": {versions: "\nversions:\n- effective: 2025/03/17 15:30" + nonpaying},
+		"2-SwitchInTheQuota":   {versions: "\nversions:\n- effective: 2025/03/17 16:15" + sequences},
+		"3-SeveralSwitches":    {versions: "\nversions:\n- effective: 2025/03/17 14:30" + nonpaying + "- effective: 2025/03/17 16:15" + sequences},
+	}
+
+	now := time.Date(2025, 3, 17, 14, 0, 0, 0, time.UTC)
+	compute := func(t *testing.T, descr string) Output {
+		t.Helper()
+		tariff, err := ParseTariffDefinition([]byte(descr))
+		if err != nil {
+			t.Fatalf("failed to parse tariff definition: %v", err)
+		}
+		out, err := tariff.Compute(now, nil)
+		if err != nil {
+			t.Fatalf("failed to compute tariff: %v", err)
+		}
+		return out
+	}
+	expected := compute(t, base)
+	if amount := expected.AmountForDuration(2 * time.Hour); amount != mustParseAmount("2.50") {
+		t.Fatalf("expected 2.50 for 2h without versions, got %s", amount)
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := compute(t, base+testcase.versions)
+			for d := time.Duration(0); d <= 10*time.Hour; d += 5 * time.Minute {
+				if got, want := out.AmountForDuration(d), expected.AmountForDuration(d); got != want {
+					t.Errorf("amount for %s: expected %s, got %s", d, want, got)
+				}
+			}
+			if !reflect.DeepEqual(out.Quotas, expected.Quotas) {
+				t.Errorf("expected quotas %v, got %v", expected.Quotas, out.Quotas)
+			}
+		})
+	}
+}
+
+func TestParseOtherwiseWithoutQuota(t *testing.T) {
+	_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "free"
+      hourlyrate: 0
+      duration: 30m
+      otherwise:
+        linear:
+          name: "paid"
+          hourlyrate: 1.0
+          duration: 30m
+`))
+	if err == nil || !strings.Contains(err.Error(), "otherwise rule RelativeLinearRule paid set without quota") {
+		t.Errorf("expected an otherwise without quota error, got %v", err)
+	}
+}
+
+func TestParseGranularityRounding(t *testing.T) {
+	tests := map[string]struct {
+		rule     string
+		expected string
+	}{
+		"0-WithoutGranularity": {rule: "rounding: down", expected: "rounding set without granularity"},
+		"1-UnknownRounding":    {rule: "granularity: 15m\n      rounding: half", expected: "unknown rounding half"},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: 1.0
+      duration: 2h
+      ` + testcase.rule + `
+`))
+			if err == nil || !strings.Contains(err.Error(), testcase.expected) {
+				t.Errorf("expected error %q, got %v", testcase.expected, err)
+			}
+		})
+	}
+}
+
+func TestParseFlatRateWithoutPeriod(t *testing.T) {
+	_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - flatrate:
+      name: "cap"
+      amount: 15.0
+`))
+	if err == nil || !strings.Contains(err.Error(), "missing period for flat rate cap") {
+		t.Errorf("expected a missing period error, got %v", err)
+	}
+}
+
+func TestParseOverlayWithoutAmounts(t *testing.T) {
+	_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - overlay:
+      name: "peak"
+      start: pattern(*/* 17:00)
+      end: pattern(*/* 19:00)
+`))
+	if err == nil || !strings.Contains(err.Error(), "missing factor or hourlyrate for overlay peak") {
+		t.Errorf("expected a missing factor error, got %v", err)
+	}
+}
+
+func TestParseGraceWithoutDuration(t *testing.T) {
+	for name, duration := range map[string]string{"0-Missing": "", "1-Zero": "\n      duration: 0s"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTariffDefinition([]byte(`
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - grace:
+      name: "tolerance"` + duration + `
+`))
+			if err == nil || !strings.Contains(err.Error(), "invalid duration") || !strings.Contains(err.Error(), "for grace tolerance") {
+				t.Errorf("expected an invalid duration error, got %v", err)
+			}
+		})
+	}
+}
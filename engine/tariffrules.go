@@ -9,28 +9,57 @@ import (
 )
 
 type BaseRule struct {
-	RuleName string `yaml:"name"`
+	RuleName string     `yaml:"name"`
+	When     *Condition `yaml:"when"`
 	Meta     MetaData
 }
 
+// Applies checks if the rule applies to the customer profile
+func (r BaseRule) Applies(profile CustomerProfile) (bool, error) {
+	return r.When.Match(profile)
+}
+
 type SolvableRule interface {
-	ToSolverRules(from, to time.Time, iterator func(SolverRule))
+	ToSolverRules(from, to time.Time, iterator func(SolverRule)) error
+	Applies(profile CustomerProfile) (bool, error)
 	String() string
 }
 
 type SolvableRules []SolvableRule
 
+// resolveRuleQuota returns the quota named by a rule, an amount quota is rejected as the amount is only known
+// once the rules are solved, so it can only be used by a sequence or the tariff
+func resolveRuleQuota(ctx context.Context, name string) (Quota, error) {
+	quota, ok := ContextGetQuotaByName(ctx, name)
+	if !ok {
+		return nil, fmt.Errorf("unknown quota: %s", name)
+	}
+	if amountQuota, ok := quota.(*AmountQuota); ok {
+		return nil, fmt.Errorf("amount quota %s cannot be used by a rule, only by a sequence or the tariff", amountQuota.Name)
+	}
+	return quota, nil
+}
+
 type LinearSequentialRule struct {
 	BaseRule
-	Quota      Quota
-	Duration   time.Duration
-	HourlyRate Amount
+	Quota       Quota
+	Otherwise   SolvableRule
+	Duration    time.Duration
+	HourlyRate  Amount
+	Granularity time.Duration
+	Rounding    Rounding
 }
 
-func (r LinearSequentialRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) {
-	solverRule := NewLinearSequentialRule(r.RuleName, r.Duration, r.HourlyRate, r.Meta)
+func (r LinearSequentialRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) error {
+	otherwise, err := otherwiseRules(r.Otherwise, from, to)
+	if err != nil {
+		return err
+	}
+	solverRule := NewLinearSequentialRule(r.RuleName, r.Duration, r.HourlyRate, r.Meta).WithGranularity(r.Granularity, r.Rounding)
 	solverRule.Quota = r.Quota
+	solverRule.Otherwise = otherwise
 	appender(solverRule)
+	return nil
 }
 
 func (r LinearSequentialRule) String() string {
@@ -38,12 +67,14 @@ func (r LinearSequentialRule) String() string {
 }
 
 func (r *LinearSequentialRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
-	var ok bool
 	temp := struct {
-		BaseRule   `yaml:",inline"`
-		QuotaName  string        `yaml:"quota"`
-		Duration   time.Duration `yaml:"duration"`
-		HourlyRate Amount        `yaml:"hourlyrate"`
+		BaseRule    `yaml:",inline"`
+		QuotaName   string         `yaml:"quota"`
+		Otherwise   *OtherwiseRule `yaml:"otherwise"`
+		Duration    time.Duration  `yaml:"duration"`
+		HourlyRate  Amount         `yaml:"hourlyrate"`
+		Granularity time.Duration  `yaml:"granularity"`
+		Rounding    *Rounding      `yaml:"rounding"`
 	}{}
 
 	// Unmarshal the base rule
@@ -54,33 +85,46 @@ func (r *LinearSequentialRule) UnmarshalYAML(ctx context.Context, unmarshal func
 
 	// Set the fields of the LinearSequentialRule
 	r.BaseRule = temp.BaseRule
-	r.Quota, ok = ContextGetQuotaByName(ctx, temp.QuotaName)
-	if !ok {
-		return fmt.Errorf("unknown quota: %s", temp.QuotaName)
+	r.Quota, err = resolveRuleQuota(ctx, temp.QuotaName)
+	if err != nil {
+		return err
+	}
+	r.Otherwise, err = temp.Otherwise.forQuota(r.Quota)
+	if err != nil {
+		return err
 	}
 	r.Duration = temp.Duration
 	r.HourlyRate = temp.HourlyRate
-	return nil
+	r.Granularity = temp.Granularity
+	r.Rounding, err = granularityRounding(temp.Granularity, temp.Rounding)
+	return err
 }
 
 type FixedRateSequentialRule struct {
 	BaseRule
-	Quota    Quota
-	Duration time.Duration
-	Amount   Amount
-	Repeat   int
+	Quota     Quota
+	Otherwise SolvableRule
+	Duration  time.Duration
+	Amount    Amount
+	Repeat    int
 }
 
-func (r FixedRateSequentialRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) {
+func (r FixedRateSequentialRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) error {
+	otherwise, err := otherwiseRules(r.Otherwise, from, to)
+	if err != nil {
+		return err
+	}
 	if r.Repeat < 1 {
 		r.Repeat = 1
 	}
 	for i := 0; i < r.Repeat; i++ {
 		solverRule := NewFixedRateSequentialRule(r.RuleName, r.Duration, r.Amount, r.Meta)
 		solverRule.Quota = r.Quota
+		solverRule.Otherwise = otherwise
 		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Repetition no%d", i))
 		appender(solverRule)
 	}
+	return nil
 }
 
 func (r FixedRateSequentialRule) String() string {
@@ -88,13 +132,13 @@ func (r FixedRateSequentialRule) String() string {
 }
 
 func (r *FixedRateSequentialRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
-	var ok bool
 	temp := struct {
 		BaseRule  `yaml:",inline"`
-		QuotaName string        `yaml:"quota"`
-		Duration  time.Duration `yaml:"duration"`
-		Amount    Amount        `yaml:"amount"`
-		Repeat    int           `yaml:"repeat"`
+		QuotaName string         `yaml:"quota"`
+		Otherwise *OtherwiseRule `yaml:"otherwise"`
+		Duration  time.Duration  `yaml:"duration"`
+		Amount    Amount         `yaml:"amount"`
+		Repeat    int            `yaml:"repeat"`
 	}{}
 
 	// Unmarshal the base rule
@@ -105,9 +149,13 @@ func (r *FixedRateSequentialRule) UnmarshalYAML(ctx context.Context, unmarshal f
 
 	// Set the fields of the FixedRateSequentialRule
 	r.BaseRule = temp.BaseRule
-	r.Quota, ok = ContextGetQuotaByName(ctx, temp.QuotaName)
-	if !ok {
-		return fmt.Errorf("unknown quota: %s", temp.QuotaName)
+	r.Quota, err = resolveRuleQuota(ctx, temp.QuotaName)
+	if err != nil {
+		return err
+	}
+	r.Otherwise, err = temp.Otherwise.forQuota(r.Quota)
+	if err != nil {
+		return err
 	}
 	r.Duration = temp.Duration
 	r.Amount = temp.Amount
@@ -118,22 +166,34 @@ func (r *FixedRateSequentialRule) UnmarshalYAML(ctx context.Context, unmarshal f
 type LinearFixedRule struct {
 	BaseRule
 	timeutils.RecurrentTimeSpan
-	Quota      Quota
-	HourlyRate Amount
+	Quota       Quota
+	Otherwise   SolvableRule
+	HourlyRate  Amount
+	Granularity time.Duration
+	Rounding    Rounding
 }
 
 // Unrolling the recurrent segment into a list of solver rules
-func (r LinearFixedRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) {
+func (r LinearFixedRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) error {
+	otherwise, err := otherwiseRules(r.Otherwise, from, to)
+	if err != nil {
+		return err
+	}
 	cnt := 0
-	r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+	err = r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
 		ts := timespan.ToRelativeTimeSpan(from)
-		solverRule := NewLinearFixedRule(r.RuleName, ts, r.HourlyRate, r.Meta)
+		solverRule := NewLinearFixedRule(r.RuleName, ts, r.HourlyRate, r.Meta).WithGranularity(r.Granularity, r.Rounding)
 		solverRule.Quota = r.Quota
+		solverRule.Otherwise = otherwise
 		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
 		appender(solverRule)
 		cnt++
 		return true
 	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
 }
 
 func (r LinearFixedRule) String() string {
@@ -141,12 +201,14 @@ func (r LinearFixedRule) String() string {
 }
 
 func (r *LinearFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
-	var ok bool
 	temp := struct {
 		BaseRule                    `yaml:",inline"`
 		timeutils.RecurrentTimeSpan `yaml:",inline"`
-		QuotaName                   string `yaml:"quota"`
-		HourlyRate                  Amount `yaml:"hourlyrate"`
+		QuotaName                   string         `yaml:"quota"`
+		Otherwise                   *OtherwiseRule `yaml:"otherwise"`
+		HourlyRate                  Amount         `yaml:"hourlyrate"`
+		Granularity                 time.Duration  `yaml:"granularity"`
+		Rounding                    *Rounding      `yaml:"rounding"`
 	}{}
 
 	// Unmarshal the base rule
@@ -158,32 +220,48 @@ func (r *LinearFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(inte
 	// Set the fields of the LinearFixedRule
 	r.BaseRule = temp.BaseRule
 	r.RecurrentTimeSpan = temp.RecurrentTimeSpan
-	r.Quota, ok = ContextGetQuotaByName(ctx, temp.QuotaName)
-	if !ok {
-		return fmt.Errorf("unknown quota: %s", temp.QuotaName)
+	r.Quota, err = resolveRuleQuota(ctx, temp.QuotaName)
+	if err != nil {
+		return err
+	}
+	r.Otherwise, err = temp.Otherwise.forQuota(r.Quota)
+	if err != nil {
+		return err
 	}
 	r.HourlyRate = temp.HourlyRate
-	return nil
+	r.Granularity = temp.Granularity
+	r.Rounding, err = granularityRounding(temp.Granularity, temp.Rounding)
+	return err
 }
 
 type FixedRateFixedRule struct {
 	BaseRule
 	timeutils.RecurrentTimeSpan
-	Quota  Quota
-	Amount Amount
+	Quota     Quota
+	Otherwise SolvableRule
+	Amount    Amount
 }
 
-func (r FixedRateFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) {
+func (r FixedRateFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
+	otherwise, err := otherwiseRules(r.Otherwise, from, to)
+	if err != nil {
+		return err
+	}
 	cnt := 0
-	r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+	err = r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
 		ts := timespan.ToRelativeTimeSpan(from)
 		solverRule := NewFixedRateFixedRule(r.RuleName, ts, r.Amount, r.Meta)
 		solverRule.Quota = r.Quota
+		solverRule.Otherwise = otherwise
 		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
 		iterator(solverRule)
 		cnt++
 		return true
 	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
 }
 
 func (r FixedRateFixedRule) String() string {
@@ -191,12 +269,12 @@ func (r FixedRateFixedRule) String() string {
 }
 
 func (r *FixedRateFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
-	var ok bool
 	temp := struct {
 		BaseRule                    `yaml:",inline"`
 		timeutils.RecurrentTimeSpan `yaml:",inline"`
-		QuotaName                   string `yaml:"quota"`
-		Amount                      Amount `yaml:"amount"`
+		QuotaName                   string         `yaml:"quota"`
+		Otherwise                   *OtherwiseRule `yaml:"otherwise"`
+		Amount                      Amount         `yaml:"amount"`
 	}{}
 
 	// Unmarshal the base rule
@@ -208,9 +286,13 @@ func (r *FixedRateFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(i
 	// Set the fields of the FixedRateFixedRule
 	r.BaseRule = temp.BaseRule
 	r.RecurrentTimeSpan = temp.RecurrentTimeSpan
-	r.Quota, ok = ContextGetQuotaByName(ctx, temp.QuotaName)
-	if !ok {
-		return fmt.Errorf("unknown quota: %s", temp.QuotaName)
+	r.Quota, err = resolveRuleQuota(ctx, temp.QuotaName)
+	if err != nil {
+		return err
+	}
+	r.Otherwise, err = temp.Otherwise.forQuota(r.Quota)
+	if err != nil {
+		return err
 	}
 	r.Amount = temp.Amount
 	return nil
@@ -219,21 +301,31 @@ func (r *FixedRateFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(i
 type FlatRateFixedRule struct {
 	BaseRule
 	timeutils.RecurrentTimeSpan
-	Quota  Quota
-	Amount Amount
+	Quota     Quota
+	Otherwise SolvableRule
+	Amount    Amount
 }
 
-func (r FlatRateFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) {
+func (r FlatRateFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
+	otherwise, err := otherwiseRules(r.Otherwise, from, to)
+	if err != nil {
+		return err
+	}
 	cnt := 0
-	r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+	err = r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
 		ts := timespan.ToRelativeTimeSpan(from)
 		solverRule := NewFlatRateFixedRule(r.RuleName, ts, r.Amount, r.Meta)
 		solverRule.Quota = r.Quota
+		solverRule.Otherwise = otherwise
 		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
 		iterator(solverRule)
 		cnt++
 		return true
 	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
 }
 
 func (r FlatRateFixedRule) String() string {
@@ -241,12 +333,12 @@ func (r FlatRateFixedRule) String() string {
 }
 
 func (r *FlatRateFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
-	var ok bool
 	temp := struct {
 		BaseRule                    `yaml:",inline"`
 		timeutils.RecurrentTimeSpan `yaml:",inline"`
-		QuotaName                   string `yaml:"quota"`
-		Amount                      Amount `yaml:"amount"`
+		QuotaName                   string         `yaml:"quota"`
+		Otherwise                   *OtherwiseRule `yaml:"otherwise"`
+		Amount                      Amount         `yaml:"amount"`
 	}{}
 
 	// Unmarshal the base rule
@@ -258,10 +350,64 @@ func (r *FlatRateFixedRule) UnmarshalYAML(ctx context.Context, unmarshal func(in
 	// Set the fields of the FlatRateFixedRule
 	r.BaseRule = temp.BaseRule
 	r.RecurrentTimeSpan = temp.RecurrentTimeSpan
-	r.Quota, ok = ContextGetQuotaByName(ctx, temp.QuotaName)
-	if !ok {
-		return fmt.Errorf("unknown quota: %s", temp.QuotaName)
+	r.Quota, err = resolveRuleQuota(ctx, temp.QuotaName)
+	if err != nil {
+		return err
+	}
+	r.Otherwise, err = temp.Otherwise.forQuota(r.Quota)
+	if err != nil {
+		return err
+	}
+	r.Amount = temp.Amount
+	return nil
+}
+
+// FlatRateSequentialRule caps the amount charged in each period from the beginning of the parking, it is an
+// absolute flat rate repeated every period from now
+type FlatRateSequentialRule struct {
+	BaseRule
+	Period time.Duration
+	Amount Amount
+}
+
+// Unrolling the periods into a list of flat rate solver rules, the last period ends with the window. The periods
+// start at from, the quote now, as every tariff version is solved from it.
+func (r FlatRateSequentialRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
+	window := to.Sub(from)
+	cnt := 0
+	for start := time.Duration(0); start < window; start += r.Period {
+		ts := timeutils.RelativeTimeSpan{From: start, To: min(start+r.Period, window)}
+		solverRule := NewFlatRateFixedRule(r.RuleName, ts, r.Amount, r.Meta)
+		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Period no%d", cnt))
+		iterator(solverRule)
+		cnt++
+	}
+	return nil
+}
+
+func (r FlatRateSequentialRule) String() string {
+	return fmt.Sprintf("FlatRateSequentialRule %s", r.RuleName)
+}
+
+func (r *FlatRateSequentialRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	temp := struct {
+		BaseRule `yaml:",inline"`
+		Period   time.Duration `yaml:"period"`
+		Amount   Amount        `yaml:"amount"`
+	}{}
+
+	// Unmarshal the base rule
+	err := unmarshal(&temp)
+	if err != nil {
+		return err
 	}
+	if temp.Period <= 0 {
+		return fmt.Errorf("missing period for flat rate %s", temp.RuleName)
+	}
+
+	// Set the fields of the FlatRateSequentialRule
+	r.BaseRule = temp.BaseRule
+	r.Period = temp.Period
 	r.Amount = temp.Amount
 	return nil
 }
@@ -273,9 +419,9 @@ type NonPayingFixedRule struct {
 
 type AbsoluteNonPayingRules []NonPayingFixedRule
 
-func (r NonPayingFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) {
+func (r NonPayingFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
 	cnt := 0
-	r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+	err := r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
 		ts := timespan.ToRelativeTimeSpan(from)
 		solverRule := NewNonPayingFixedRule(r.RuleName, ts, r.Meta)
 		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
@@ -283,6 +429,10 @@ func (r NonPayingFixedRule) ToSolverRules(from, to time.Time, iterator func(Solv
 		cnt++
 		return true
 	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
 }
 
 func (r NonPayingFixedRule) String() string {
@@ -290,46 +440,249 @@ func (r NonPayingFixedRule) String() string {
 
 }
 
-func (rules *SolvableRules) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
+// BannedFixedRule forbids parking during its recurrent timespan (street cleaning, market, night ban...),
+// the parking right cannot be bought across it
+type BannedFixedRule struct {
+	BaseRule                    `yaml:",inline"`
+	timeutils.RecurrentTimeSpan `yaml:",inline"`
+}
+
+type AbsoluteBannedRules []BannedFixedRule
+
+func (r BannedFixedRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
+	cnt := 0
+	err := r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+		ts := timespan.ToRelativeTimeSpan(from)
+		solverRule := NewBannedFixedRule(r.RuleName, ts, r.Meta)
+		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
+		iterator(solverRule)
+		cnt++
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
+}
+
+func (r BannedFixedRule) String() string {
+	return fmt.Sprintf("AbsoluteBannedRule %s", r.RuleName)
+}
+
+// OverlayRule changes the amounts of the paying rules under its recurrent timespan once they are solved, without
+// moving them. The amounts are multiplied by Factor, then HourlyRate is added for the time overlapped, a negative
+// rate is a discount.
+type OverlayRule struct {
+	BaseRule
+	timeutils.RecurrentTimeSpan
+	Factor     Amount
+	HourlyRate Amount
+}
+
+func (r OverlayRule) ToSolverRules(from, to time.Time, iterator func(SolverRule)) error {
+	cnt := 0
+	err := r.RecurrentTimeSpan.BetweenIterator(from, to, func(timespan timeutils.AbsTimeSpan) bool {
+		ts := timespan.ToRelativeTimeSpan(from)
+		solverRule := NewOverlayRule(r.RuleName, ts, r.Factor, r.HourlyRate, r.Meta)
+		solverRule.Trace = append(solverRule.Trace, fmt.Sprintf("Occurence no%d", cnt))
+		iterator(solverRule)
+		cnt++
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%w for rule %s: %w", ErrRecurrentRule, r.RuleName, err)
+	}
+	return nil
+}
 
-	temp := []struct {
-		LinearSequentialRate    *LinearSequentialRule    `yaml:"linear"`
-		FixedRateSequentialRule *FixedRateSequentialRule `yaml:"fixedrate"`
-		LinearFixedRule         *LinearFixedRule         `yaml:"abslinear"`
-		FlatRateFixedRule       *FlatRateFixedRule       `yaml:"absflatrate"`
-		FixedRateFixedRule      *FixedRateFixedRule      `yaml:"absfixedrate"`
-		NonPayingFixedRule      *NonPayingFixedRule      `yaml:"nonpaying"`
+func (r OverlayRule) String() string {
+	return fmt.Sprintf("OverlayRule %s", r.RuleName)
+}
+
+func (r *OverlayRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	temp := struct {
+		BaseRule                    `yaml:",inline"`
+		timeutils.RecurrentTimeSpan `yaml:",inline"`
+		Factor                      *Amount `yaml:"factor"`
+		HourlyRate                  Amount  `yaml:"hourlyrate"`
 	}{}
 
+	// Unmarshal the base rule
 	err := unmarshal(&temp)
 	if err != nil {
 		return err
 	}
+	if temp.Factor == nil && temp.HourlyRate == 0 {
+		return fmt.Errorf("missing factor or hourlyrate for overlay %s", temp.RuleName)
+	}
 
-	//fmt.Println("SolvableRules UnmarshalYAML", temp)
+	// Set the fields of the OverlayRule, the amounts are not multiplied by default
+	r.BaseRule = temp.BaseRule
+	r.RecurrentTimeSpan = temp.RecurrentTimeSpan
+	r.Factor = AmountUnit
+	if temp.Factor != nil {
+		r.Factor = *temp.Factor
+	}
+	r.HourlyRate = temp.HourlyRate
+	return nil
+}
+
+// GraceRule gives a free period at the beginning of the parking. A retroactive grace period is free only if the
+// parking ends within it, once the parking goes past it the period is charged by the rules under it.
+type GraceRule struct {
+	BaseRule    `yaml:",inline"`
+	Duration    time.Duration `yaml:"duration"`
+	Retroactive bool          `yaml:"retroactive"`
+}
+
+func (r GraceRule) ToSolverRules(from, to time.Time, appender func(SolverRule)) error {
+	appender(NewGraceRule(r.RuleName, r.Duration, r.Retroactive, r.Meta))
+	return nil
+}
+
+func (r GraceRule) String() string {
+	return fmt.Sprintf("GraceRule %s", r.RuleName)
+}
+
+func (r *GraceRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
+	temp := struct {
+		BaseRule    `yaml:",inline"`
+		Duration    time.Duration `yaml:"duration"`
+		Retroactive bool          `yaml:"retroactive"`
+	}{}
+
+	err := unmarshal(&temp)
+	if err != nil {
+		return err
+	}
+	if temp.Duration <= 0 {
+		return fmt.Errorf("invalid duration %s for grace %s", temp.Duration, temp.RuleName)
+	}
+
+	r.BaseRule = temp.BaseRule
+	r.Duration = temp.Duration
+	r.Retroactive = temp.Retroactive
+	return nil
+}
+
+// solvableRuleItem is a rule item of the YAML description, only one rule kind can be set
+type solvableRuleItem struct {
+	LinearSequentialRate    *LinearSequentialRule    `yaml:"linear"`
+	FixedRateSequentialRule *FixedRateSequentialRule `yaml:"fixedrate"`
+	FlatRateSequentialRule  *FlatRateSequentialRule  `yaml:"flatrate"`
+	LinearFixedRule         *LinearFixedRule         `yaml:"abslinear"`
+	FlatRateFixedRule       *FlatRateFixedRule       `yaml:"absflatrate"`
+	FixedRateFixedRule      *FixedRateFixedRule      `yaml:"absfixedrate"`
+	NonPayingFixedRule      *NonPayingFixedRule      `yaml:"nonpaying"`
+	BannedFixedRule         *BannedFixedRule         `yaml:"absbanned"`
+	GraceRule               *GraceRule               `yaml:"grace"`
+	OverlayRule             *OverlayRule             `yaml:"overlay"`
+}
+
+// rule returns the rule set in the item, nil if the item is empty
+func (t solvableRuleItem) rule() (SolvableRule, error) {
+	if !isOnlyOneFieldSet(t) {
+		return nil, fmt.Errorf("several rule kinds set in one rule item")
+	}
+	if t.LinearSequentialRate != nil {
+		return t.LinearSequentialRate, nil
+	} else if t.FixedRateSequentialRule != nil {
+		return t.FixedRateSequentialRule, nil
+	} else if t.FlatRateSequentialRule != nil {
+		return t.FlatRateSequentialRule, nil
+	} else if t.LinearFixedRule != nil {
+		return t.LinearFixedRule, nil
+	} else if t.FlatRateFixedRule != nil {
+		return t.FlatRateFixedRule, nil
+	} else if t.FixedRateFixedRule != nil {
+		return t.FixedRateFixedRule, nil
+	} else if t.NonPayingFixedRule != nil {
+		return t.NonPayingFixedRule, nil
+	} else if t.BannedFixedRule != nil {
+		return t.BannedFixedRule, nil
+	} else if t.GraceRule != nil {
+		return t.GraceRule, nil
+	} else if t.OverlayRule != nil {
+		return t.OverlayRule, nil
+	}
+	return nil, nil
+}
+
+func (rules *SolvableRules) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
+
+	temp := []solvableRuleItem{}
+
+	err := unmarshal(&temp)
+	if err != nil {
+		return err
+	}
 
 	*rules = make(SolvableRules, 0, len(temp))
 	for _, t := range temp {
-		rule := SolvableRule(nil)
-		// TODO return an error if more then one field is set
-		if t.LinearSequentialRate != nil {
-			rule = t.LinearSequentialRate
-		} else if t.FixedRateSequentialRule != nil {
-			rule = t.FixedRateSequentialRule
-		} else if t.LinearFixedRule != nil {
-			rule = t.LinearFixedRule
-		} else if t.FlatRateFixedRule != nil {
-			rule = t.FlatRateFixedRule
-		} else if t.FixedRateFixedRule != nil {
-			rule = t.FixedRateFixedRule
-		} else if t.NonPayingFixedRule != nil {
-			rule = t.NonPayingFixedRule
+		rule, err := t.rule()
+		if err != nil {
+			return err
 		}
 		if rule != nil {
 			*rules = append(*rules, rule)
 		}
 	}
+	return nil
+}
+
+// OtherwiseRule is the rule replacing a rule with a quota for the time the quota could not give, it can be
+// of any rule kind
+type OtherwiseRule struct {
+	SolvableRule
+}
 
-	//fmt.Println("SolvableRules UnmarshalYAML", rules)
+func (r *OtherwiseRule) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
+	var item solvableRuleItem
+	if err := unmarshal(&item); err != nil {
+		return err
+	}
+	rule, err := item.rule()
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		return fmt.Errorf("missing otherwise rule")
+	}
+	r.SolvableRule = rule
 	return nil
 }
+
+// forQuota returns the otherwise rule of a rule with the quota, it is nil if not set
+func (r *OtherwiseRule) forQuota(quota Quota) (SolvableRule, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if quota == nil {
+		return nil, fmt.Errorf("otherwise rule %s set without quota", r.SolvableRule)
+	}
+	return r.SolvableRule, nil
+}
+
+// otherwiseRules unrolls the otherwise rule, the rules are laid out over the time the quota could not give
+func otherwiseRules(otherwise SolvableRule, from, to time.Time) (SolverRules, error) {
+	if otherwise == nil {
+		return nil, nil
+	}
+	var rules SolverRules
+	err := otherwise.ToSolverRules(from, to, func(rule SolverRule) {
+		rules = append(rules, rule)
+	})
+	return rules, err
+}
+
+// granularityRounding returns the rounding of a linear rule with the granularity, each started unit is charged
+// unless another rounding is set
+func granularityRounding(granularity time.Duration, rounding *Rounding) (Rounding, error) {
+	if rounding == nil {
+		return RoundUp, nil
+	}
+	if granularity == 0 {
+		return RoundUp, fmt.Errorf("rounding set without granularity")
+	}
+	return *rounding, nil
+}
@@ -3,10 +3,10 @@ package engine
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
-	"github.com/iem-rd/quote-engine/table"
 	"github.com/iem-rd/quote-engine/timeutils"
 )
 
@@ -17,6 +17,11 @@ type TariffSequence struct {
 	Rules          SolvableRules
 	Solver         Solver
 	Limits         TariffLimits
+	// ResetLimits resets the limits on each occurrence of the validity period, by default the limits apply
+	// once to all the occurrences in the window
+	ResetLimits bool
+	// When restricts the sequence to some customers, see CustomerProfile
+	When *Condition
 }
 
 // New TariffSequence from a name, a recurrent segment and a quota
@@ -39,20 +44,110 @@ func (ts TariffSequence) String() string {
 	return sb.String()
 }
 
-func (ts TariffSequence) Solve(now time.Time, window time.Duration, globalNonpaying AbsoluteNonPayingRules) {
-	fmt.Println()
-	table.TitleTheme().Println("Solving sequence", ts.Name)
+// Solve the sequence rules over the window, the quotas are used by the rules from quotasFrom (see Solver.SetQuotas)
+func (ts *TariffSequence) Solve(now time.Time, window, quotasFrom time.Duration, globalBanned AbsoluteBannedRules, globalNonpaying AbsoluteNonPayingRules, quotas QuotaInventory) error {
+	ts.Solver.logger.Debug("solve sequence", "sequence", ts.Name)
+	ts.Solver.dump.Title("Solving sequence", ts.Name)
 
 	ts.Solver.SetWindow(now, window)
-	// Append first all global nonpaying rules...
+	ts.Solver.SetQuotas(quotas, quotasFrom)
+	// Append first all banned rules, global and from the sequence, so they take precedence over any other fixed rule...
+	for i := range globalBanned {
+		if err := globalBanned[i].ToSolverRules(now, now.Add(window), ts.Solver.Append); err != nil {
+			return err
+		}
+	}
+	for i := range ts.Rules {
+		if _, banned := ts.Rules[i].(*BannedFixedRule); !banned {
+			continue
+		}
+		if err := ts.Rules[i].ToSolverRules(now, now.Add(window), ts.Solver.Append); err != nil {
+			return err
+		}
+	}
+	// ... then all global nonpaying rules...
 	for i := range globalNonpaying {
-		globalNonpaying[i].ToSolverRules(now, now.Add(window), ts.Solver.Append)
+		if err := globalNonpaying[i].ToSolverRules(now, now.Add(window), ts.Solver.Append); err != nil {
+			return err
+		}
 	}
-	// ... then the sequence rules
+	// ... then the grace rules, free over the paying rules at the beginning of the parking...
 	for i := range ts.Rules {
-		ts.Rules[i].ToSolverRules(now, now.Add(window), ts.Solver.Append)
+		if _, grace := ts.Rules[i].(*GraceRule); !grace {
+			continue
+		}
+		if err := ts.Rules[i].ToSolverRules(now, now.Add(window), ts.Solver.Append); err != nil {
+			return err
+		}
+	}
+	// ... then the other sequence rules
+	for i := range ts.Rules {
+		switch ts.Rules[i].(type) {
+		case *BannedFixedRule, *GraceRule:
+			continue
+		}
+		if err := ts.Rules[i].ToSolverRules(now, now.Add(window), ts.Solver.Append); err != nil {
+			return err
+		}
+	}
+	return ts.Solver.Solve()
+}
+
+// quota returns the sequence quota from the quotas inventory, so its usage state is the one of the computation
+func (ts TariffSequence) quota(quotas QuotaInventory) Quota {
+	if ts.Quota == nil {
+		return nil
+	}
+	if quota, exists := quotas[ts.Quota.GetName()]; exists {
+		return quota
+	}
+	return ts.Quota
+}
+
+// occurrenceStart returns the start of the validity period occurrence containing the date
+func (ts TariffSequence) occurrenceStart(date time.Time) (time.Time, error) {
+	_, occurrence, err := ts.ValidityPeriod.IsWithin(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w for sequence %s validity period: %w", ErrRecurrentRule, ts.Name, err)
+	}
+	return occurrence.Start, nil
+}
+
+// limitsKey identifies the scheduler entries sharing the same sequence limits
+type limitsKey struct {
+	sequence   *TariffSequence
+	occurrence time.Time
+}
+
+// limitsUsage is the amount and duration already added to the output by the scheduler entries sharing the same limits
+type limitsUsage struct {
+	amount   Amount
+	duration time.Duration
+}
+
+// remaining returns the limits left for a scheduler entry starting at from, false is returned if a limit is
+// already reached
+func (usage limitsUsage) remaining(limits TariffLimits, from time.Duration) (TariffLimits, bool) {
+	if limits.MaxAmount > 0 {
+		if usage.amount >= limits.MaxAmount {
+			return limits, false
+		}
+		limits.MaxAmount -= usage.amount
+	}
+	if limits.MaxDuration > 0 {
+		if usage.duration >= limits.MaxDuration {
+			return limits, false
+		}
+		limits.MaxDuration += from - usage.duration
+	}
+	return limits, true
+}
+
+func (usage *limitsUsage) add(rules SolverRules) {
+	for _, rule := range rules {
+		usage.amount += rule.EndAmount
+		usage.duration += rule.Duration()
 	}
-	ts.Solver.Solve()
 }
 
 type TariffSequenceInventory []TariffSequence
@@ -78,62 +173,128 @@ func (tsi TariffSequenceInventory) String() string {
 	return sb.String()
 }
 
-// Merge all sequences into a single list of rules
-func (inventory TariffSequenceInventory) Merge(now time.Time, window time.Duration) (SolverRules, error) { //TODO remove error
+// Merge all sequences into a single list of rules, the parts of the sequences validity periods without
+// rules are returned as gaps. The sequences quotas are taken from the quotas inventory and used by the rules
+// from quotasFrom, the rules before it were given by a previous tariff version.
+func (inventory TariffSequenceInventory) Merge(now time.Time, window, quotasFrom time.Duration, quotas QuotaInventory, logger *slog.Logger, dump *RulesDump) (SolverRules, SequenceGaps, error) {
 	var out SolverRules
+	var gaps SequenceGaps
 
 	if len(inventory) == 0 {
-		return out, nil
+		return out, gaps, nil
 	}
 
 	// If there is only one sequence, return its rules directly, skipping merging
 	if len(inventory) == 1 {
-		fmt.Println("Single sequence, skipping merging")
-		return inventory[0].Solver.ExtractRulesInRange(timeutils.RelativeTimeSpan{From: 0, To: window}), nil
+		logger.Debug("single sequence, skipping merging")
+		timespan := timeutils.RelativeTimeSpan{From: 0, To: window}
+		out = inventory[0].Solver.ExtractRulesInRange(timespan)
+		if quota := inventory[0].quota(quotas); quota != nil {
+			out, _ = out.applyQuotaFrom(quota, quotasFrom, logger)
+		}
+		return out, out.Gaps(timespan, inventory[0].Name), nil
 	}
 
 	// Create a scheduler and solve all sequences excepted the last one
-	scheduler := NewScheduler()
+	scheduler := NewScheduler(logger)
 	scheduler.SetWindow(now, window)
 	for i := range (inventory)[:len(inventory)-1] {
-		scheduler.AddSequence(&inventory[i])
+		if err := scheduler.AddSequence(&inventory[i]); err != nil {
+			return nil, nil, err
+		}
 	}
 	// Add latest sequences. Lowest priority sequence must always match the window as it's the default one
 	scheduler.Append(SchedulerEntry{
 		RelativeTimeSpan: timeutils.RelativeTimeSpan{From: 0, To: window},
 		Sequence:         &inventory[len(inventory)-1],
 	})
-	fmt.Println("Scheduler:", scheduler.String())
+	logger.Debug("scheduler entries", "entries", &scheduler)
+
+	// The limits and a counter quota are used once by a sequence, even if the sequence has several scheduler entries
+	usages := map[limitsKey]*limitsUsage{}
+	granted := map[*TariffSequence]bool{}
+	// The amounts deferred by a grace period ending with an entry are charged by the next entry with rules
+	var deferred []deferredCharge
+	var err error
 
 	// Merge all sequences
 	scheduler.entries.Ascend(func(entry SchedulerEntry) bool {
-		rules := entry.Sequence.Solver.ExtractRulesInRange(entry.RelativeTimeSpan)
-		fmt.Println("\nMerging", entry.Sequence.Name, len(rules), "rules between", entry.RelativeTimeSpan, "to", len(out), "rules already in output")
+		rules, left := entry.Sequence.Solver.extractRulesInRange(entry.RelativeTimeSpan)
+		logger.Debug("merge sequence", "sequence", entry.Sequence.Name, "rules", len(rules), "timespan", entry.RelativeTimeSpan, "output", len(out))
+
+		dump.PrintRules(fmt.Sprintf("Rules from %s before applying limits (%d rules):", entry.Sequence.Name, len(rules)), now, rules)
+
+		// Apply the sequence limits, what is left of them after the previous entries of the sequence (or of the
+		// validity period occurrence if the limits are reset) starts at the beginning of the entry
+		key := limitsKey{sequence: entry.Sequence}
+		if entry.Sequence.ResetLimits {
+			key.occurrence, err = entry.Sequence.occurrenceStart(now.Add(entry.From))
+			if err != nil {
+				return false
+			}
+		}
+		if usages[key] == nil {
+			usages[key] = &limitsUsage{}
+		}
+		if limits, ok := usages[key].remaining(entry.Sequence.Limits, entry.From); ok {
+			rules = rules.ApplyLimits(limits, logger)
+		} else {
+			logger.Debug("sequence limits reached, rules removed", "sequence", entry.Sequence.Name, "timespan", entry.RelativeTimeSpan)
+			rules = SolverRules{}
+		}
+		usages[key].add(rules)
 
-		rules.PrintAsTable(fmt.Sprintf("Rules from %s before applying limits (%d rules):", entry.Sequence.Name, len(rules)), now)
+		dump.PrintRules(fmt.Sprintf("Rules from %s with limits applied (%d rules):", entry.Sequence.Name, len(rules)), now, rules)
 
-		// Calcul the position of the rules in the output and apply the sequence limits
-		limits := entry.Sequence.Limits
-		offsetAmout, offsetDuration := out.SumAll()
-		limits.AddOffset(offsetAmout, offsetDuration)
-		rules = rules.ApplyLimits(limits)
-		// FIXME: the limits are applied for each sheduler entries but should be applied only once for all scheduler entries from the same sequence
-		// For example if one sequence has 2 entries, the limits are applied twice instead of once globally
+		// Truncate the rules once the sequence quota is used up
+		if quota := entry.Sequence.quota(quotas); quota != nil && !granted[entry.Sequence] {
+			var used time.Duration
+			rules, used = rules.applyQuotaFrom(quota, quotasFrom, logger)
+			if _, isCounter := quota.(*CounterQuota); isCounter && used > 0 {
+				granted[entry.Sequence] = true
+			}
+			dump.PrintRules(fmt.Sprintf("Rules from %s with quota %s applied (%d rules):", entry.Sequence.Name, quota.GetName(), len(rules)), now, rules)
+		}
 
-		rules.PrintAsTable(fmt.Sprintf("Rules from %s with limits applied (%d rules):", entry.Sequence.Name, len(rules)), now)
+		if len(rules) > 0 {
+			for _, charge := range deferred {
+				rules = rules.ChargeDeferred(charge.amount, charge.grace)
+			}
+			deferred = nil
+		}
+		deferred = append(deferred, left...)
 
 		out = append(out, rules...)
+		gaps = append(gaps, rules.Gaps(entry.RelativeTimeSpan, entry.Sequence.Name)...)
 		return true
 	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return out, nil
+	return out, gaps, nil
 }
 
-func (inventory TariffSequenceInventory) Solve(now time.Time, window time.Duration, globalNonpaying AbsoluteNonPayingRules) {
+func (inventory TariffSequenceInventory) Solve(now time.Time, window, quotasFrom time.Duration, globalBanned AbsoluteBannedRules, globalNonpaying AbsoluteNonPayingRules, quotas QuotaInventory) error {
 	//Solve all sequences individually
 	for i := range inventory {
-		inventory[i].Solve(now, window, globalNonpaying)
+		if err := inventory[i].Solve(now, window, quotasFrom, globalBanned, globalNonpaying, quotas); err != nil {
+			return fmt.Errorf("failed to solve sequence %s: %w", inventory[i].Name, err)
+		}
 	}
+	return nil
+}
+
+// WithNewSolvers returns a copy of the inventory where each sequence has its own empty solver,
+// the sequences definitions are shared but not the solving state
+func (inventory TariffSequenceInventory) WithNewSolvers(logger *slog.Logger, dump *RulesDump) TariffSequenceInventory {
+	out := make(TariffSequenceInventory, len(inventory))
+	for i := range inventory {
+		out[i] = inventory[i]
+		out[i].Solver = NewSolver()
+		out[i].Solver.SetLogger(logger, dump)
+	}
+	return out
 }
 
 func (out *TariffSequenceInventory) UnmarshalYAML(ctx context.Context, unmarshal func(interface{}) error) error {
@@ -145,6 +306,8 @@ func (out *TariffSequenceInventory) UnmarshalYAML(ctx context.Context, unmarshal
 		Quota          string                      `yaml:"quota,"`
 		Rules          SolvableRules               `yaml:"rules"`
 		Limits         TariffLimits                `yaml:",inline"`
+		ResetLimits    bool                        `yaml:"resetlimits"`
+		When           *Condition                  `yaml:"when"`
 	}{}
 	err := unmarshal(&temp)
 	if err != nil {
@@ -160,6 +323,8 @@ func (out *TariffSequenceInventory) UnmarshalYAML(ctx context.Context, unmarshal
 		seq.ValidityPeriod = n.ValidityPeriod
 		seq.Rules = n.Rules
 		seq.Limits = n.Limits
+		seq.ResetLimits = n.ResetLimits
+		seq.When = n.When
 
 		// Some validity check
 		isValidityPeriodValid := n.ValidityPeriod.Start != nil && n.ValidityPeriod.End != nil
@@ -171,6 +336,10 @@ func (out *TariffSequenceInventory) UnmarshalYAML(ctx context.Context, unmarshal
 			// Last sequence must have an empty validity period
 			return fmt.Errorf("last sequence must have an empty validity period")
 		}
+		if n.When != nil && isLastSequence {
+			// Last sequence is the default one, it must apply to all customers
+			return fmt.Errorf("last sequence must not have a when condition")
+		}
 
 		// Search the coresponding quota
 		if n.Quota != "" {
@@ -19,9 +19,9 @@ func unmarshalTimeDuration(duration *time.Duration, data []byte) error {
 	return nil
 }
 
-func unmarshalRecurrentDate(rec *timeutils.RecurrentDate, data []byte) error {
+func unmarshalRecurrentDate(rec *timeutils.RecurrentDate, data []byte, loc *time.Location) error {
 	str := strings.Trim(string(data), `"`)
-	tmp, err := timeutils.ParseRecurrentDate(str)
+	tmp, err := timeutils.ParseRecurrentDateInLocation(str, loc)
 	if err != nil {
 		return err
 	}
@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"path/filepath"
 	"reflect"
+	"strings"
 )
 
 // Check if only one field of the passed struct is set
@@ -21,3 +23,8 @@ func isOnlyOneFieldSet(s interface{}) bool {
 	}
 	return true
 }
+
+// fileNameWithoutExtension returns the file name without its extension, the tariffs are identified by it
+func fileNameWithoutExtension(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
@@ -0,0 +1,439 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+	"github.com/iem-rd/quote-engine/timeutils"
+)
+
+// Severity represents the severity of a validation diagnostic
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic represents a single problem found in a tariff definition
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Stringer for Diagnostic, use the usual file:line:column: severity: message form
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+type Diagnostics []Diagnostic
+
+// HasErrors returns true if at least one diagnostic is an error
+func (diags Diagnostics) HasErrors() bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (diags Diagnostics) ToJson() ([]byte, error) {
+	return json.Marshal(diags)
+}
+
+// Stringer for Diagnostics, one diagnostic per line
+func (diags Diagnostics) String() string {
+	var sb strings.Builder
+	for _, d := range diags {
+		sb.WriteString(d.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// ValidateTariffDefinitionFile validates a tariff definition file, see ValidateTariffDefinition
+func ValidateTariffDefinitionFile(filename string) (Diagnostics, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateTariffDefinition(filename, data), nil
+}
+
+// ValidateTariffDefinition checks a tariff definition and returns all the problems found, located in the
+// YAML source. Beside the parser errors, it also reports constructions accepted by the parser but most
+// likely wrong such as zero durations or negative amounts.
+func ValidateTariffDefinition(filename string, data []byte) Diagnostics {
+	v := validator{file: filename, quotas: map[string]bool{}}
+
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		v.addError(err)
+		return v.diags
+	}
+	for _, doc := range file.Docs {
+		v.validateRoot(doc.Body)
+	}
+
+	// Parse the tariff for real to catch everything not covered by the checks above
+	if _, err := ParseTariffDefinition(data); err != nil {
+		v.addError(err)
+	}
+
+	sort.SliceStable(v.diags, func(i, j int) bool {
+		if v.diags[i].Line == v.diags[j].Line {
+			return v.diags[i].Column < v.diags[j].Column
+		}
+		return v.diags[i].Line < v.diags[j].Line
+	})
+	return v.diags
+}
+
+type validator struct {
+	file   string
+	diags  Diagnostics
+	quotas map[string]bool
+}
+
+func (v *validator) add(pos *token.Position, severity Severity, format string, args ...interface{}) {
+	d := Diagnostic{File: v.file, Line: 1, Column: 1, Severity: severity, Message: fmt.Sprintf(format, args...)}
+	if pos != nil {
+		d.Line, d.Column = pos.Line, pos.Column
+	}
+	v.diags = append(v.diags, d)
+}
+
+var yamlErrorPositionRegex = regexp.MustCompile(`^\[\d+:\d+\] `)
+
+// addError converts a parsing error into a diagnostic, unless the same problem was already reported
+func (v *validator) addError(err error) {
+	var pos *token.Position
+	msg := err.Error()
+
+	// goccy/go-yaml errors carry the token in error
+	var tk *token.Token
+	var syntaxErr *yaml.SyntaxError
+	var typeErr *yaml.TypeError
+	var overflowErr *yaml.OverflowError
+	var duplicateErr *yaml.DuplicateKeyError
+	var unknownErr *yaml.UnknownFieldError
+	var nodeTypeErr *yaml.UnexpectedNodeTypeError
+	var nodeErr *NodeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		tk, msg = syntaxErr.Token, syntaxErr.Message
+	case errors.As(err, &typeErr):
+		tk, msg = typeErr.Token, typeErr.FormatError(false, false)
+	case errors.As(err, &overflowErr):
+		tk, msg = overflowErr.Token, overflowErr.FormatError(false, false)
+	case errors.As(err, &duplicateErr):
+		tk, msg = duplicateErr.Token, duplicateErr.Message
+	case errors.As(err, &unknownErr):
+		tk, msg = unknownErr.Token, unknownErr.Message
+	case errors.As(err, &nodeTypeErr):
+		tk, msg = nodeTypeErr.Token, nodeTypeErr.FormatError(false, false)
+	case errors.As(err, &nodeErr):
+		pos = nodePosition(nodeErr.Node)
+	}
+	if tk != nil {
+		pos = tk.Position
+	}
+	msg = yamlErrorPositionRegex.ReplaceAllString(msg, "")
+
+	// Skip the error if it was already reported with a more accurate position
+	cause := err
+	for errors.Unwrap(cause) != nil {
+		cause = errors.Unwrap(cause)
+	}
+	for _, d := range v.diags {
+		if d.Severity == SeverityError && (strings.Contains(err.Error(), d.Message) || strings.HasPrefix(d.Message, cause.Error())) {
+			return
+		}
+	}
+	v.add(pos, SeverityError, "%s", msg)
+}
+
+func (v *validator) validateRoot(root ast.Node) {
+	entries := mappingEntries(root)
+	if entries == nil {
+		v.add(nodePosition(root), SeverityError, "tariff definition must be a mapping")
+		return
+	}
+
+	sections := map[string]*ast.MappingValueNode{}
+	for _, entry := range entries {
+		sections[entryKey(entry)] = entry
+	}
+
+	if version, ok := sections["version"]; !ok {
+		v.add(nodePosition(root), SeverityError, "invalid tariff version: ")
+	} else if scalarValue(version.Value) != "0.1" {
+		v.add(nodePosition(version.Value), SeverityError, "invalid tariff version: %s", scalarValue(version.Value))
+	}
+
+	if config, ok := sections["config"]; ok {
+		v.validateConfig(config.Value)
+	}
+	if quotas, ok := sections["quotas"]; ok {
+		v.validateQuotas(quotas.Value)
+	}
+	if config, ok := sections["config"]; ok {
+		for _, entry := range mappingEntries(config.Value) {
+			if entryKey(entry) == "quota" {
+				v.checkQuotaReference(entry)
+			}
+		}
+	}
+	if sequences, ok := sections["sequences"]; ok {
+		v.validateSequences(sequences.Value)
+	} else {
+		v.add(nodePosition(root), SeverityError, "sequences section is missing")
+	}
+	if versions, ok := sections["versions"]; ok {
+		for _, item := range sequenceItems(versions.Value) {
+			for _, entry := range mappingEntries(item) {
+				switch entryKey(entry) {
+				case "quotas":
+					v.validateQuotas(entry.Value)
+				case "sequences":
+					v.validateSequences(entry.Value)
+				}
+			}
+		}
+	}
+
+	v.validateAmounts(root, false)
+}
+
+func (v *validator) validateConfig(config ast.Node) {
+	for _, entry := range mappingEntries(config) {
+		switch entryKey(entry) {
+		case "window":
+			v.checkNonZeroDuration(entry)
+		case "timezone":
+			if _, err := LoadLocation(scalarValue(entry.Value)); err != nil {
+				v.add(nodePosition(entry.Value), SeverityError, "invalid timezone: %v", err)
+			}
+		case "fillgaps":
+			var fill DurationType
+			if err := fill.UnmarshalText([]byte(scalarValue(entry.Value))); err != nil {
+				v.add(nodePosition(entry.Value), SeverityError, "invalid fillgaps: %v", err)
+			} else if fill == PayingDuration {
+				v.add(nodePosition(entry.Value), SeverityError, "invalid fillgaps: gaps cannot be filled with paying segments")
+			}
+		}
+	}
+}
+
+func (v *validator) validateQuotas(quotas ast.Node) {
+	for _, item := range sequenceItems(quotas) {
+		entries := mappingEntries(item)
+		if len(entries) > 1 {
+			v.add(nodePosition(item), SeverityError, "several quota types set in one quota item")
+		}
+		for _, entry := range entries {
+			for _, field := range mappingEntries(entry.Value) {
+				switch entryKey(field) {
+				case "name":
+					v.quotas[scalarValue(field.Value)] = true
+				case "rolling":
+					v.checkNonZeroDuration(field)
+				case "allowance":
+					switch entryKey(entry) {
+					case "duration":
+						v.checkNonZeroDuration(field)
+					case "amount":
+						if _, err := ParseAmount(scalarValue(field.Value)); err != nil {
+							v.add(nodePosition(field.Value), SeverityError, "%s", err.Error())
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateSequences(sequences ast.Node) {
+	items := sequenceItems(sequences)
+	for i, item := range items {
+		name := ""
+		hasStart, hasEnd := false, false
+		for _, field := range mappingEntries(item) {
+			switch entryKey(field) {
+			case "name":
+				name = scalarValue(field.Value)
+			case "start":
+				hasStart = true
+			case "end":
+				hasEnd = true
+			case "quota":
+				v.checkQuotaReference(field)
+			case "rules":
+				v.validateRules(field.Value)
+			}
+		}
+
+		isValidityPeriodValid := hasStart && hasEnd
+		isLastSequence := i == len(items)-1
+		if !isValidityPeriodValid && !isLastSequence {
+			v.add(nodePosition(item), SeverityError, "validity period is not valid for sequence %s", name)
+		}
+		if isValidityPeriodValid && isLastSequence {
+			v.add(nodePosition(item), SeverityError, "last sequence must have an empty validity period")
+		}
+	}
+}
+
+func (v *validator) validateRules(rules ast.Node) {
+	for _, item := range sequenceItems(rules) {
+		v.validateRule(item)
+	}
+}
+
+// validateRule checks a rule item, including its otherwise rule
+func (v *validator) validateRule(item ast.Node) {
+	entries := mappingEntries(item)
+	if len(entries) > 1 {
+		kinds := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			kinds = append(kinds, entryKey(entry))
+		}
+		v.add(nodePosition(item), SeverityError, "several rule kinds set in one rule item (%s)", strings.Join(kinds, ", "))
+	}
+	for _, entry := range entries {
+		for _, field := range mappingEntries(entry.Value) {
+			switch entryKey(field) {
+			case "quota":
+				v.checkQuotaReference(field)
+			case "duration", "granularity", "period":
+				v.checkNonZeroDuration(field)
+			case "otherwise":
+				v.validateRule(field.Value)
+			}
+		}
+	}
+}
+
+// validateAmounts walks the whole tree and reports invalid or negative amounts, the overlays may have negative
+// amounts as they are discounts
+func (v *validator) validateAmounts(node ast.Node, negativeAllowed bool) {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		for _, entry := range n.Values {
+			v.validateAmounts(entry, negativeAllowed)
+		}
+	case *ast.MappingValueNode:
+		switch entryKey(n) {
+		case "amount", "hourlyrate", "maxamount", "factor":
+			// The amount quotas are mappings under the same key
+			if _, ok := n.Value.(*ast.MappingNode); ok {
+				v.validateAmounts(n.Value, negativeAllowed)
+				break
+			}
+			amount, err := ParseAmount(scalarValue(n.Value))
+			if err != nil {
+				v.add(nodePosition(n.Value), SeverityError, "%s", err.Error())
+			} else if amount < 0 && (!negativeAllowed || entryKey(n) == "factor") {
+				v.add(nodePosition(n.Value), SeverityError, "negative amount for %s: %s", entryKey(n), scalarValue(n.Value))
+			}
+		case "overlay":
+			v.validateAmounts(n.Value, true)
+		default:
+			v.validateAmounts(n.Value, negativeAllowed)
+		}
+	case *ast.SequenceNode:
+		for _, item := range n.Values {
+			v.validateAmounts(item, negativeAllowed)
+		}
+	}
+}
+
+func (v *validator) checkQuotaReference(field *ast.MappingValueNode) {
+	name := scalarValue(field.Value)
+	if name != "" && !v.quotas[name] {
+		v.add(nodePosition(field.Value), SeverityError, "unknown quota: %s", name)
+	}
+}
+
+func (v *validator) checkNonZeroDuration(field *ast.MappingValueNode) {
+	d, err := timeutils.ParseDuration(scalarValue(field.Value))
+	if err == nil && d == 0 {
+		v.add(nodePosition(field.Value), SeverityWarning, "zero %s", entryKey(field))
+	}
+}
+
+// mappingEntries returns the key/value entries of a mapping node, or nil if the node is not a mapping
+func mappingEntries(node ast.Node) []*ast.MappingValueNode {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		return n.Values
+	case *ast.MappingValueNode:
+		return []*ast.MappingValueNode{n}
+	}
+	return nil
+}
+
+// sequenceItems returns the items of a sequence node, or nil if the node is not a sequence
+func sequenceItems(node ast.Node) []ast.Node {
+	if n, ok := node.(*ast.SequenceNode); ok {
+		return n.Values
+	}
+	return nil
+}
+
+func entryKey(entry *ast.MappingValueNode) string {
+	return entry.Key.GetToken().Value
+}
+
+func scalarValue(node ast.Node) string {
+	if node == nil || node.GetToken() == nil {
+		return ""
+	}
+	return node.GetToken().Value
+}
+
+// nodePosition returns the position of the first token of a node
+func nodePosition(node ast.Node) *token.Position {
+	if node == nil {
+		return nil
+	}
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		if len(n.Values) > 0 {
+			return nodePosition(n.Values[0])
+		}
+	case *ast.MappingValueNode:
+		return n.Key.GetToken().Position
+	}
+	if node.GetToken() == nil {
+		return nil
+	}
+	return node.GetToken().Position
+}
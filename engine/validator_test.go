@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateTariffDefinition(t *testing.T) {
+	tests := map[string]struct {
+		tariff   string
+		expected Diagnostics
+	}{
+		"0-Valid": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: 1.0
+      duration: 10h
+`,
+			expected: Diagnostics{},
+		},
+		"1-SeveralRuleKinds": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: 1.0
+      duration: 10h
+    fixedrate:
+      name: "fixed"
+      amount: 1.0
+      duration: 10h
+`,
+			expected: Diagnostics{
+				{Line: 6, Column: 5, Severity: SeverityError, Message: "several rule kinds set in one rule item (linear, fixedrate)"},
+			},
+		},
+		"2-SeveralQuotaTypes": {
+			tariff: `
+version: "0.1"
+quotas:
+- duration:
+    name: "q1"
+    periodicity: duration(4h)
+    allowance: 2h
+  counter:
+    name: "q2"
+    periodicity: duration(4h)
+    allowance: 2
+sequences:
+- name: "default"
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 4, Column: 3, Severity: SeverityError, Message: "several quota types set in one quota item"},
+			},
+		},
+		"3-ZeroDurationsAndNegativeAmounts": {
+			tariff: `
+version: "0.1"
+config:
+  window: 0s
+  maxamount: -10
+sequences:
+- name: "default"
+  rules:
+  - fixedrate:
+      name: "fixed"
+      amount: -0.50
+      duration: 0s
+`,
+			expected: Diagnostics{
+				{Line: 4, Column: 11, Severity: SeverityWarning, Message: "zero window"},
+				{Line: 5, Column: 14, Severity: SeverityError, Message: "negative amount for maxamount: -10"},
+				{Line: 11, Column: 15, Severity: SeverityError, Message: "negative amount for amount: -0.50"},
+				{Line: 12, Column: 17, Severity: SeverityWarning, Message: "zero duration"},
+			},
+		},
+		"4-UnknownQuotaAndValidityPeriods": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "morning"
+  quota: "unknown"
+  rules: []
+- name: "default"
+  start: pattern(*/* 08:00)
+  end: pattern(*/* 12:00)
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 4, Column: 3, Severity: SeverityError, Message: "validity period is not valid for sequence morning"},
+				{Line: 5, Column: 10, Severity: SeverityError, Message: "unknown quota: unknown"},
+				{Line: 7, Column: 3, Severity: SeverityError, Message: "last sequence must have an empty validity period"},
+			},
+		},
+		"5-ParserErrors": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      hourlyrate: abc
+      unknown: 1
+`,
+			expected: Diagnostics{
+				{Line: 8, Column: 19, Severity: SeverityError, Message: `invalid amount "abc"`},
+			},
+		},
+		"6-UnknownField": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      unknown: 1
+`,
+			expected: Diagnostics{
+				{Line: 8, Column: 7, Severity: SeverityError, Message: `unknown field "unknown"`},
+			},
+		},
+		"7-InvalidVersion": {
+			tariff: `
+version: "0.2"
+sequences:
+- name: "default"
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 2, Column: 10, Severity: SeverityError, Message: "invalid tariff version: 0.2"},
+			},
+		},
+		"8-InvalidTimezone": {
+			tariff: `
+version: "0.1"
+config:
+  window: 24h
+  timezone: Europe/Nowhere
+sequences:
+- name: "default"
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 5, Column: 13, Severity: SeverityError, Message: "invalid timezone: unknown time zone Europe/Nowhere"},
+			},
+		},
+		"9-PayingFillGaps": {
+			tariff: `
+version: "0.1"
+config:
+  fillgaps: p
+sequences:
+- name: "default"
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 4, Column: 13, Severity: SeverityError, Message: "invalid fillgaps: gaps cannot be filled with paying segments"},
+			},
+		},
+		"10-UnknownConfigQuota": {
+			tariff: `
+version: "0.1"
+config:
+  quota: "cap"
+sequences:
+- name: "default"
+  rules: []
+`,
+			expected: Diagnostics{
+				{Line: 4, Column: 10, Severity: SeverityError, Message: "unknown quota: cap"},
+			},
+		},
+		"11-OtherwiseZeroDuration": {
+			tariff: `
+version: "0.1"
+quotas:
+- duration:
+    name: "free"
+    periodicity: duration(24h)
+    allowance: 30m
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "free"
+      duration: 30m
+      hourlyrate: 0
+      quota: "free"
+      otherwise:
+        fixedrate:
+          name: "paid"
+          duration: 0s
+          amount: 0.50
+`,
+			expected: Diagnostics{
+				{Line: 19, Column: 21, Severity: SeverityWarning, Message: "zero duration"},
+			},
+		},
+		"12-ZeroGranularity": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - linear:
+      name: "hourly"
+      duration: 2h
+      hourlyrate: 1.0
+      granularity: 0s
+`,
+			expected: Diagnostics{
+				{Line: 10, Column: 20, Severity: SeverityWarning, Message: "zero granularity"},
+			},
+		},
+		"13-NegativeOverlayFactor": {
+			tariff: `
+version: "0.1"
+sequences:
+- name: "default"
+  rules:
+  - overlay:
+      name: "discount"
+      start: pattern(*/* 17:00)
+      end: pattern(*/* 19:00)
+      hourlyrate: -0.5
+      factor: -1.0
+  - linear:
+      name: "hourly"
+      duration: 2h
+      hourlyrate: 1.0
+`,
+			expected: Diagnostics{
+				{Line: 11, Column: 15, Severity: SeverityError, Message: "negative amount for factor: -1.0"},
+			},
+		},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			diags := ValidateTariffDefinition("tariff.yaml", []byte(testcase.tariff))
+			if len(diags) != len(testcase.expected) {
+				t.Fatalf("expected %d diagnostics, got %d:\n%s", len(testcase.expected), len(diags), diags)
+			}
+			for i, expected := range testcase.expected {
+				expected.File = "tariff.yaml"
+				if diags[i] != expected {
+					t.Errorf("expected diagnostic %s, got %s", expected, diags[i])
+				}
+			}
+		})
+	}
+}
+
+// All tariffs used by the tests must be valid
+func TestValidateTestdataTariffs(t *testing.T) {
+	files, err := filepath.Glob("testdata/*/*.yaml")
+	if err != nil {
+		t.Fatalf("failed to list test tariffs: %v", err)
+	}
+	for _, file := range files {
+		diags, err := ValidateTariffDefinitionFile(file)
+		if err != nil {
+			t.Fatalf("failed to validate %s: %v", file, err)
+		}
+		if diags.HasErrors() {
+			t.Errorf("unexpected errors in %s:\n%s", file, diags)
+		}
+	}
+}
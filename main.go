@@ -2,77 +2,91 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
 	"time"
-
-	"github.com/iem-rd/quote-engine/engine"
+	_ "time/tzdata" // tariff time zones must be available even if the system has no time zone database
 )
 
-func main() {
+// Process exit codes, the build pipeline relies on them to detect failures
+const (
+	exitOK           = 0
+	exitFailure      = 1 // I/O or unexpected error
+	exitUsage        = 2 // Invalid command line
+	exitParseError   = 3 // Tariff or history file could not be parsed
+	exitComputeError = 4 // Tariff table could not be computed
+)
 
-	plop :=
-		`
-version: "0.1"
-config:
-  window: 5d
-quotas:
-  - duration:
-      name: "plop"
-      periodicity: duration(4h)
-      allowance: 2h10m
-      matching:
-        - layer: z*
-          type: paying
-        - layer: t*	
-          type: nonpaying
+type command struct {
+	name  string
+	descr string
+	run   func(args []string) int
+}
 
-sequences:
-- name: "BC01"
-  rules:
-  - absflatrate:
-      name: "daily"
-      start: pattern(*/* 00:00)
-      end: pattern(*/* 00:00)
-      amount: 3.50
-  - fixedrate:
-      name: "minamount"
-      duration: 42m
-      amount: 0.70
-      quota: "plop"
-  - linear:
-      name: "hourlyrate"
-      hourlyrate: 1.0
-      duration: 10h
-      quota: "plop"
+var commands = []command{
+	{"processor", "compute the tariff table of a tariff file and write it as JSON", runProcessor},
+	{"player", "answer amount and duration queries against a saved table", runPlayer},
+	{"validate", "check tariff files and report problems with their position", runValidate},
+	{"serve", "answer quote requests over HTTP for the tariffs of a directory", runServe},
+}
 
-`
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.descr)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the command options.\n", os.Args[0])
+}
 
-	t, err := engine.ParseTariffDefinition([]byte(plop))
-	if err != nil {
-		panic(err)
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
 	}
-	fmt.Printf("%v\n", t)
 
-	//now := time.Now()
-	now, _ := time.ParseInLocation("2006-01-02T15:04:05", "2025-03-14T15:54:30", time.Local)
-	out := t.Compute(now, []engine.AssignedRight{})
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
 
-	json, _ := out.ToJson()
-	fmt.Println(string(json))
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
 
-	//t.Sequences.Solve(time.Now(), time.Hour*24*7)
+// parseTime parses a time either as RFC3339 or as a time without zone (2006-01-02T15:04:05) in the given location
+// An empty string returns the current time
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or 2006-01-02T15:04:05", value)
+	}
+	return t, nil
+}
 
+// writeOutput writes data to the given file, or to stdout if filename is empty or "-"
+func writeOutput(filename string, data []byte) error {
+	if filename == "" || filename == "-" {
+		_, err := os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
 }
 
-// plop:
-//       type: duration
-//       allowance: 2h10m
-//       periodicity: duration(4h)
-//       matching:
-//         - area: z*
-//           type: paying
-//         - area: t*
-//           type: nonpaying
-//   plip:
-//       type: counter
-//       allowance: 42
-//       periodicity: pattern(*/* 12:00)
+// fail prints the error on stderr and returns the given exit code
+func fail(code int, format string, args ...interface{}) int {
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	fmt.Fprint(os.Stderr, msg)
+	return code
+}
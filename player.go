@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iem-rd/quote-engine/engine"
+	"github.com/iem-rd/quote-engine/timeutils"
+)
+
+// runPlayer implements the player command: answer amount and duration queries against a saved table
+//
+//	go run . player -f output/table.json -a 1.50
+//	go run . player -f output/table.json -d 2h30m
+//	go run . player -f output/table.json -e 2024-11-28T18:00:00
+func runPlayer(args []string) int {
+	var filename, amountStr, durationStr, endStr string
+
+	fs := flag.NewFlagSet("player", flag.ContinueOnError)
+	fs.StringVar(&filename, "f", "", "table file (JSON) generated by the processor command")
+	fs.StringVar(&filename, "file", "", "table file (JSON) generated by the processor command")
+	fs.StringVar(&amountStr, "a", "", "amount paid (ex: 1.50), returns the parking duration and end date")
+	fs.StringVar(&amountStr, "amount", "", "amount paid (ex: 1.50), returns the parking duration and end date")
+	fs.StringVar(&durationStr, "d", "", "parking duration (ex: 2h30m), returns the amount to pay")
+	fs.StringVar(&durationStr, "duration", "", "parking duration (ex: 2h30m), returns the amount to pay")
+	fs.StringVar(&endStr, "e", "", "parking end date (RFC3339 or 2006-01-02T15:04:05 in the table time zone), returns the amount to pay")
+	fs.StringVar(&endStr, "end", "", "parking end date (RFC3339 or 2006-01-02T15:04:05 in the table time zone), returns the amount to pay")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if filename == "" {
+		fs.Usage()
+		return fail(exitUsage, "missing table file (-f)")
+	}
+	if amountStr == "" && durationStr == "" && endStr == "" {
+		fs.Usage()
+		return fail(exitUsage, "at least one of amount (-a), duration (-d) or end date (-e) is required")
+	}
+
+	var amount engine.Amount
+	if amountStr != "" {
+		var err error
+		amount, err = engine.ParseAmount(amountStr)
+		if err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+		if amount < 0 {
+			return fail(exitUsage, "amount %s is negative", amount)
+		}
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fail(exitFailure, "failed to read table: %v", err)
+	}
+	out, err := engine.LoadOutputFromJSON(data)
+	if err != nil {
+		return fail(exitParseError, "failed to parse table %s: %v", filename, err)
+	}
+
+	if amountStr != "" {
+		duration := out.DurationForAmount(amount)
+		fmt.Printf("amount %s -> duration %s, end %s\n", amount, duration, out.EndDateForAmount(amount).Format(time.RFC3339))
+	}
+
+	if durationStr != "" {
+		duration, err := timeutils.ParseDuration(durationStr)
+		if err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+		fmt.Printf("duration %s -> amount %s, end %s\n", duration, out.AmountForDuration(duration), out.Now.Add(duration).Format(time.RFC3339))
+	}
+
+	if endStr != "" {
+		end, err := parseTime(endStr, out.Now.Location())
+		if err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+		if end.Before(out.Now) {
+			return fail(exitUsage, "end date %s is before table reference time %s", end.Format(time.RFC3339), out.Now.Format(time.RFC3339))
+		}
+		duration := end.Sub(out.Now)
+		fmt.Printf("end %s -> amount %s, duration %s\n", end.Format(time.RFC3339), out.AmountForDuration(duration), duration)
+	}
+
+	return exitOK
+}
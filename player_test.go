@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePlayerTable writes a table of 2h linear at 2.00 per hour starting at now
+func writePlayerTable(t *testing.T, now string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "table.json")
+	table := `{"now": "` + now + `", "table": [{"d": 7200, "a": 4, "l": true, "dt": "p"}]}`
+	if err := os.WriteFile(filename, []byte(table), 0644); err != nil {
+		t.Fatalf("failed to write table: %v", err)
+	}
+	return filename
+}
+
+// runPlayerOutput runs the player command and returns its exit code and standard output
+func runPlayerOutput(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	code := runPlayer(args)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	r.Close()
+	return code, string(out)
+}
+
+func TestPlayerAmount(t *testing.T) {
+	filename := writePlayerTable(t, "2025-03-17T08:00:00Z")
+
+	tests := map[string]struct {
+		amount   string
+		code     int
+		expected string
+	}{
+		"0-Integer":    {amount: "1", code: exitOK, expected: "amount 1.00 -> duration 30m0s, end 2025-03-17T08:30:00Z"},
+		"1-NonInteger": {amount: "1.50", code: exitOK, expected: "amount 1.50 -> duration 45m0s, end 2025-03-17T08:45:00Z"},
+		"2-Cents":      {amount: "0.05", code: exitOK, expected: "amount 0.05 -> duration 1m30s, end 2025-03-17T08:01:30Z"},
+		"3-Invalid":    {amount: "1,50", code: exitUsage},
+		"4-Negative":   {amount: "-1", code: exitUsage},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, out := runPlayerOutput(t, "-f", filename, "-a", testcase.amount)
+			if code != testcase.code {
+				t.Fatalf("expected exit code %d, got %d", testcase.code, code)
+			}
+			if out = strings.TrimSpace(out); out != testcase.expected {
+				t.Errorf("expected %q, got %q", testcase.expected, out)
+			}
+		})
+	}
+}
+
+// An end date without offset is in the time zone of the table, not of the machine
+func TestPlayerEnd(t *testing.T) {
+	filename := writePlayerTable(t, "2025-03-17T08:00:00+01:00")
+
+	tests := map[string]struct {
+		end      string
+		expected string
+	}{
+		"0-TableTimeZone": {end: "2025-03-17T09:00:00", expected: "end 2025-03-17T09:00:00+01:00 -> amount 2.00, duration 1h0m0s"},
+		"1-RFC3339":       {end: "2025-03-17T08:30:00Z", expected: "end 2025-03-17T08:30:00Z -> amount 3.00, duration 1h30m0s"},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, out := runPlayerOutput(t, "-f", filename, "-e", testcase.end)
+			if code != exitOK {
+				t.Fatalf("expected exit code %d, got %d", exitOK, code)
+			}
+			if out = strings.TrimSpace(out); out != testcase.expected {
+				t.Errorf("expected %q, got %q", testcase.expected, out)
+			}
+		})
+	}
+}
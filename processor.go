@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/iem-rd/quote-engine/engine"
+)
+
+// runProcessor implements the processor command: load a tariff file, compute its table and write it as JSON
+//
+//	go run . processor -f samples/tariff.yaml -n 2024-11-28T16:13:00 --history rights.json -o output/table.json
+func runProcessor(args []string) int {
+	var filename, nowStr, historyFile, outFile, dumpFile, flags, cashStep string
+	var profile engine.CustomerProfile
+	var verbose, noColor bool
+
+	fs := flag.NewFlagSet("processor", flag.ContinueOnError)
+	fs.StringVar(&filename, "f", "", "tariff definition file (YAML)")
+	fs.StringVar(&filename, "file", "", "tariff definition file (YAML)")
+	fs.StringVar(&nowStr, "n", "", "reference time (RFC3339 or 2006-01-02T15:04:05 in the tariff time zone), default is current time")
+	fs.StringVar(&nowStr, "now", "", "reference time (RFC3339 or 2006-01-02T15:04:05 in the tariff time zone), default is current time")
+	fs.StringVar(&historyFile, "history", "", "optional assigned rights history file (JSON)")
+	fs.StringVar(&outFile, "o", "", "output file for the JSON table, default is stdout")
+	fs.StringVar(&outFile, "out", "", "output file for the JSON table, default is stdout")
+	fs.StringVar(&profile.TariffCode, "tariff-code", "", "customer tariff code, selects the sequences and rules with a when condition")
+	fs.StringVar(&profile.LayerCode, "layer", "", "customer layer code, selects the sequences and rules with a when condition")
+	fs.StringVar(&flags, "flags", "", "comma separated customer flags (ex: pmr,pro), selects the sequences and rules with a when condition")
+	fs.StringVar(&cashStep, "cash-step", "", "smallest amount which can be paid (ex: 0.05), the table amounts are multiples of it")
+	fs.BoolVar(&verbose, "v", false, "log the computation traces on stderr")
+	fs.BoolVar(&verbose, "verbose", false, "log the computation traces on stderr")
+	fs.StringVar(&dumpFile, "dump", "", "write the rules tables of each computation step to this file ('-' for stderr)")
+	fs.BoolVar(&noColor, "no-color", os.Getenv("NO_COLOR") != "", "disable colors in the rules tables")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if filename == "" {
+		fs.Usage()
+		return fail(exitUsage, "missing tariff file (-f)")
+	}
+
+	tariff, err := engine.ParseTariffDefinitionFile(filename)
+	if err != nil {
+		return fail(exitParseError, "failed to parse tariff %s: %v", filename, err)
+	}
+
+	now, err := parseTime(nowStr, tariff.Config.Location())
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	var history engine.AssignedRights
+	if historyFile != "" {
+		data, err := os.ReadFile(historyFile)
+		if err != nil {
+			return fail(exitFailure, "failed to read history: %v", err)
+		}
+		history, err = engine.LoadAssignedRightHistoryFromJSON(data)
+		if err != nil {
+			return fail(exitParseError, "failed to parse history %s: %v", historyFile, err)
+		}
+	}
+
+	if flags != "" {
+		profile.Flags = strings.Split(flags, ",")
+	}
+	options := []engine.ComputeOption{engine.WithProfile(profile)}
+	if cashStep != "" {
+		step, err := engine.ParseAmount(cashStep)
+		if err != nil {
+			return fail(exitUsage, "invalid cash step: %v", err)
+		}
+		if err := engine.ValidateCashStep(step); err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+		options = append(options, engine.WithCashStep(step))
+	}
+	if verbose {
+		options = append(options, engine.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	}
+	if dumpFile != "" {
+		var dump io.Writer = os.Stderr
+		if dumpFile != "-" {
+			f, err := os.Create(dumpFile)
+			if err != nil {
+				return fail(exitFailure, "failed to create dump file: %v", err)
+			}
+			defer f.Close()
+			dump = f
+		}
+		options = append(options, engine.WithRulesDump(dump, noColor))
+	}
+
+	out, err := tariff.Compute(now, history, options...)
+	if err != nil {
+		return fail(exitComputeError, "failed to compute tariff %s: %v", filename, err)
+	}
+
+	json, err := out.ToJson()
+	if err != nil {
+		return fail(exitComputeError, "failed to convert table to JSON: %v", err)
+	}
+	if err := writeOutput(outFile, json); err != nil {
+		return fail(exitFailure, "failed to write output: %v", err)
+	}
+	return exitOK
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+// The cash step is checked before computing, a step which is not a payable amount is a usage error
+func TestProcessorCashStep(t *testing.T) {
+	tests := map[string]struct {
+		step string
+		code int
+	}{
+		"0-Zero":         {step: "0", code: exitUsage},
+		"1-Negative":     {step: "-0.05", code: exitUsage},
+		"2-BelowMinimum": {step: "0.000001", code: exitUsage},
+		"3-NotAnAmount":  {step: "five", code: exitUsage},
+		"4-Valid":        {step: "0.05", code: exitOK},
+	}
+
+	for name, testcase := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := []string{"-f", "engine/testdata/devs/test_date.yaml", "-n", "2025-04-19T20:00:00", "-o", t.TempDir() + "/table.json", "--cash-step", testcase.step}
+			if code := runProcessor(args); code != testcase.code {
+				t.Errorf("expected exit code %d, got %d", testcase.code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/iem-rd/quote-engine/engine"
+)
+
+// runServe implements the serve command: load the tariffs of a directory and answer quote requests over HTTP
+//
+//	go run . serve -d samples/ --addr :8080
+func runServe(args []string) int {
+	var dir, addr string
+	var verbose bool
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&dir, "d", "", "directory of the tariff definition files (YAML)")
+	fs.StringVar(&dir, "dir", "", "directory of the tariff definition files (YAML)")
+	fs.StringVar(&addr, "addr", "localhost:8080", "listening address")
+	fs.BoolVar(&verbose, "v", false, "log the computation traces on stderr")
+	fs.BoolVar(&verbose, "verbose", false, "log the computation traces on stderr")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if dir == "" {
+		fs.Usage()
+		return fail(exitUsage, "missing tariff directory (-d)")
+	}
+
+	tariffs, err := engine.LoadTariffDirectory(dir)
+	if err != nil {
+		return fail(exitParseError, "failed to load tariffs from %s: %v", dir, err)
+	}
+
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           engine.NewServer(tariffs, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info("serving tariffs", "addr", addr, "tariffs", len(tariffs))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fail(exitFailure, "server failed: %v", err)
+	}
+	return exitOK
+}
@@ -2,13 +2,24 @@ package table
 
 import "github.com/fatih/color"
 
-func SetDefaultTheme(tbl *Table) {
-	color.NoColor = false // Force enabled color output, as color capability is not detected correctly in VSCode
-	headerFmt := color.New(color.FgHiMagenta, color.Underline).SprintfFunc()
-	columnFmt := color.New(color.FgHiBlue).SprintfFunc()
+// SetDefaultTheme sets the header and first column colors of the table. The color capability is not
+// detected as the table may not be printed on a terminal, colors are enabled unless noColor is set.
+func SetDefaultTheme(tbl *Table, noColor bool) {
+	headerFmt := themeColor(noColor, color.FgHiMagenta, color.Underline).SprintfFunc()
+	columnFmt := themeColor(noColor, color.FgHiBlue).SprintfFunc()
 	(*tbl).WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
 }
 
-func TitleTheme() *color.Color {
-	return color.New(color.FgHiYellow).Add(color.Bold)
+func TitleTheme(noColor bool) *color.Color {
+	return themeColor(noColor, color.FgHiYellow, color.Bold)
+}
+
+func themeColor(noColor bool, attributes ...color.Attribute) *color.Color {
+	c := color.New(attributes...)
+	if noColor {
+		c.DisableColor()
+	} else {
+		c.EnableColor()
+	}
+	return c
 }
@@ -1,6 +1,7 @@
 package timeutils
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -19,9 +20,18 @@ type RecurrentDate interface {
 	String() string
 }
 
+// ErrNoOccurrence is returned when a recurrent date has no more occurrence in the requested direction
+var ErrNoOccurrence = errors.New("no occurrence found")
+
 var functionRegex = regexp.MustCompile(`^(\w+)\((.+)\)$`)
 
+// ParseRecurrentDate parses a recurrent date pattern, dates and times are in the local time zone
 func ParseRecurrentDate(pattern string) (RecurrentDate, error) {
+	return ParseRecurrentDateInLocation(pattern, time.Local)
+}
+
+// ParseRecurrentDateInLocation parses a recurrent date pattern, dates and times are in the given location
+func ParseRecurrentDateInLocation(pattern string, loc *time.Location) (RecurrentDate, error) {
 	recurrentDateTypes := map[string]func(string) (RecurrentDate, error){
 		// Periodic reccurence
 		"periodic": func(arg string) (RecurrentDate, error) {
@@ -37,18 +47,18 @@ func ParseRecurrentDate(pattern string) (RecurrentDate, error) {
 		},
 		// Date pattern based reccurence
 		"pattern": func(arg string) (RecurrentDate, error) {
-			r := RecurrentDatePattern{}
+			r := RecurrentDatePattern{location: loc}
 			err := r.ParseFromDatePattern(arg)
 			return r, err
 		},
 		// RRule RFC 5545 based reccurence
 		"rrule": func(arg string) (RecurrentDate, error) {
-			r := RecurrentDatePattern{}
+			r := RecurrentDatePattern{location: loc}
 			err := r.ParseFromRRule(arg)
 			return r, err
 		},
 		"date": func(arg string) (RecurrentDate, error) {
-			r := RecurrentDateFixed{}
+			r := RecurrentDateFixed{location: loc}
 			err := r.Parse(arg)
 			return r, err
 		},
@@ -115,9 +125,20 @@ func (r RecurrentDatePeriodic) String() string {
 }
 
 // RecurrentDatePattern represents a pattern based recurrent date.
+// The occurrences are computed in the location of the pattern, local time zone by default.
 type RecurrentDatePattern struct {
 	rule        *rrule.RRule
 	origPattern string
+	location    *time.Location
+}
+
+// dtStart returns the start date of the rule in the pattern location
+func (r *RecurrentDatePattern) dtStart() time.Time {
+	loc := r.location
+	if loc == nil {
+		loc = time.Local
+	}
+	return time.Date(2020, 01, 01, 0, 0, 0, 0, loc)
 }
 
 func (r *RecurrentDatePattern) ParseFromDatePattern(pattern string) error {
@@ -125,7 +146,7 @@ func (r *RecurrentDatePattern) ParseFromDatePattern(pattern string) error {
 	if err != nil {
 		return fmt.Errorf("error while parsing %s rule pattern, %v", pattern, err)
 	}
-	rule.DTStart(time.Date(2020, 01, 01, 0, 0, 0, 0, time.Local)) //TODO: Start date must be before the current date to find the previous occurrence, see if any smarter thing can be done
+	rule.DTStart(r.dtStart()) //TODO: Start date must be before the current date to find the previous occurrence, see if any smarter thing can be done
 	r.rule = rule
 	r.origPattern = pattern
 	return nil
@@ -136,7 +157,7 @@ func (r *RecurrentDatePattern) ParseFromRRule(pattern string) error {
 	if err != nil {
 		return fmt.Errorf("error while parsing %s rule pattern, %v", pattern, err)
 	}
-	rule.DTStart(time.Date(2020, 01, 01, 0, 0, 0, 0, time.Local)) //TODO: Start date must be before the current date to find the previous occurrence, see if any smarter thing can be done
+	rule.DTStart(r.dtStart()) //TODO: Start date must be before the current date to find the previous occurrence, see if any smarter thing can be done
 	r.rule = rule
 	r.origPattern = pattern
 	return nil
@@ -147,7 +168,7 @@ func (r RecurrentDatePattern) Next(now time.Time) (time.Time, error) {
 	//TODO: check if now is not too much in the past, before DTStart constant date
 	next := r.rule.After(now, false)
 	if next.IsZero() {
-		return next, fmt.Errorf("no next occurrence found")
+		return next, fmt.Errorf("no next occurrence of %s after %s: %w", r, now, ErrNoOccurrence)
 	}
 	return next, nil
 }
@@ -157,7 +178,7 @@ func (r RecurrentDatePattern) Prev(now time.Time) (time.Time, error) {
 	//TODO: check if now is not too much in the past, before DTStart constant date
 	prev := r.rule.Before(now, false)
 	if prev.IsZero() {
-		return prev, fmt.Errorf("no previous occurrence found")
+		return prev, fmt.Errorf("no previous occurrence of %s before %s: %w", r, now, ErrNoOccurrence)
 	}
 	return prev, nil
 }
@@ -167,7 +188,7 @@ func (r RecurrentDatePattern) First(now time.Time) (time.Time, error) {
 	//TODO: check if now is not too much in the past, before DTStart constant date
 	next := r.rule.After(now, true)
 	if next.IsZero() {
-		return next, fmt.Errorf("no next occurrence found")
+		return next, fmt.Errorf("no first occurrence of %s from %s: %w", r, now, ErrNoOccurrence)
 	}
 	return next, nil
 }
@@ -182,22 +203,36 @@ func (r RecurrentDatePattern) String() string {
 	return fmt.Sprintf("rrule(%s)", r.origPattern)
 }
 
-// RecurrentDateFixed represents a fixed date (non-recurrent)
+// RecurrentDateFixed represents a fixed date (non-recurrent), in the local time zone by default
 type RecurrentDateFixed struct {
-	value time.Time
+	value    time.Time
+	location *time.Location
 }
 
 func (r *RecurrentDateFixed) Parse(pattern string) error {
-	t, err := time.ParseInLocation("2006/01/02 15:04:05", pattern, time.Local)
+	loc := r.location
+	if loc == nil {
+		loc = time.Local
+	}
+	t, err := ParseDate(pattern, loc)
+	if err != nil {
+		return err
+	}
+	r.value = t
+	return nil
+}
+
+// ParseDate parses a date as 2006/01/02 15:04:05 or 2006/01/02 15:04 in the given location
+func ParseDate(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", value, loc)
 	if err != nil {
 		// Try to parse without seconds
-		t, err = time.ParseInLocation("2006/01/02 15:04", pattern, time.Local)
+		t, err = time.ParseInLocation("2006/01/02 15:04", value, loc)
 		if err != nil {
-			return fmt.Errorf("invalid fixed date format: %s", pattern)
+			return time.Time{}, fmt.Errorf("invalid fixed date format: %s", value)
 		}
 	}
-	r.value = t
-	return nil
+	return t, nil
 }
 
 func (r RecurrentDateFixed) First(now time.Time) (time.Time, error) {
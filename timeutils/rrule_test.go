@@ -733,3 +733,46 @@ func TestParseRecurrentDate(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRecurrentDateInLocation(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	tests := []struct {
+		pattern      string
+		loc          *time.Location
+		now          time.Time
+		expectedNext time.Time
+	}{
+		// The wall clock time is kept across the DST changes
+		{"pattern(*/* 08:00)", paris, time.Date(2025, 3, 29, 20, 0, 0, 0, paris), time.Date(2025, 3, 30, 8, 0, 0, 0, paris)},
+		{"pattern(*/* 08:00)", paris, time.Date(2025, 10, 25, 20, 0, 0, 0, paris), time.Date(2025, 10, 26, 8, 0, 0, 0, paris)},
+		{"rrule(FREQ=DAILY;BYHOUR=8;BYMINUTE=0;BYSECOND=0)", paris, time.Date(2025, 10, 25, 20, 0, 0, 0, paris), time.Date(2025, 10, 26, 8, 0, 0, 0, paris)},
+		{"date(2025/10/26 08:00)", paris, time.Date(2025, 10, 25, 20, 0, 0, 0, paris), time.Date(2025, 10, 26, 8, 0, 0, 0, paris)},
+		// The now time zone doesn't matter
+		{"pattern(*/* 08:00)", tokyo, time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 8, 0, 0, 0, tokyo)},
+		{"date(2025/03/31 08:00)", tokyo, time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 8, 0, 0, 0, tokyo)},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%02d-%s", i, clearString(test.pattern)), func(t *testing.T) {
+			recurrentDate, err := ParseRecurrentDateInLocation(test.pattern, test.loc)
+			if err != nil {
+				t.Fatalf("ParseRecurrentDateInLocation(%q) error = %v", test.pattern, err)
+			}
+			next, err := recurrentDate.Next(test.now)
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			if !next.Equal(test.expectedNext) {
+				t.Errorf("Next(%v) = %v, want %v", test.now, next, test.expectedNext)
+			}
+		})
+	}
+}
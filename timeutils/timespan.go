@@ -1,6 +1,7 @@
 package timeutils
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -127,37 +128,41 @@ func (rs *RecurrentTimeSpan) Prev(now time.Time) (AbsTimeSpan, error) {
 	return s, nil
 }
 
-func (rs *RecurrentTimeSpan) Between(from, to time.Time) []AbsTimeSpan {
+func (rs *RecurrentTimeSpan) Between(from, to time.Time) ([]AbsTimeSpan, error) {
 	var segments []AbsTimeSpan
-	rs.BetweenIterator(from, to, func(s AbsTimeSpan) bool {
+	err := rs.BetweenIterator(from, to, func(s AbsTimeSpan) bool {
 		segments = append(segments, s)
 		return true
 	})
-	return segments
+	return segments, err
 }
 
-func (rs *RecurrentTimeSpan) BetweenIterator(from, to time.Time, iterator func(AbsTimeSpan) bool) {
+// BetweenIterator calls the iterator for each occurrence between from and to until it returns false.
+// Running out of occurrences is not an error, any other error when unrolling the rules is returned.
+func (rs *RecurrentTimeSpan) BetweenIterator(from, to time.Time, iterator func(AbsTimeSpan) bool) error {
 
 	// Handle the first occurrence
 	segment, err := rs.First(from)
-	if err != nil {
-		fmt.Println("Error when unrolling RRule:", err)
-		return
+	if errors.Is(err, ErrNoOccurrence) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error when unrolling %s: %w", rs, err)
 	}
 	if segment.Start.IsZero() || segment.Start.After(to) {
-		return
+		return nil
 	}
 	if !iterator(segment) {
-		return
+		return nil
 	}
 
 	// loop through all others following occurrences
 	now := segment.Start
 	for now.Before(to) {
 		segment, err := rs.Next(now)
-		if err != nil {
-			fmt.Println("Error when unrolling RRule:", err)
+		if errors.Is(err, ErrNoOccurrence) {
 			break
+		} else if err != nil {
+			return fmt.Errorf("error when unrolling %s: %w", rs, err)
 		}
 		if segment.Start.IsZero() || segment.Start.After(to) {
 			break
@@ -167,6 +172,7 @@ func (rs *RecurrentTimeSpan) BetweenIterator(from, to time.Time, iterator func(A
 		}
 		now = segment.Start
 	}
+	return nil
 }
 
 func (rs *RecurrentTimeSpan) IsWithin(t time.Time) (bool, AbsTimeSpan, error) {
@@ -439,7 +439,10 @@ func TestRecurrentSegment_Between(t *testing.T) {
 			rs := RecurrentTimeSpan{Start: start, End: end}
 
 			// Test Between
-			segments := rs.Between(tc.from, tc.to)
+			segments, err := rs.Between(tc.from, tc.to)
+			if err != nil {
+				t.Fatalf("unexpected error in Between: %v", err)
+			}
 
 			if len(segments) != len(tc.expected) {
 				t.Errorf("Between(%v, %v) expected %v segments, got %v", tc.from, tc.to, len(tc.expected), len(segments))
@@ -453,10 +456,13 @@ func TestRecurrentSegment_Between(t *testing.T) {
 
 			// Test BetweenIterator
 			segments = []AbsTimeSpan{}
-			rs.BetweenIterator(tc.from, tc.to, func(segment AbsTimeSpan) bool {
+			err = rs.BetweenIterator(tc.from, tc.to, func(segment AbsTimeSpan) bool {
 				segments = append(segments, segment)
 				return true
 			})
+			if err != nil {
+				t.Fatalf("unexpected error in BetweenIterator: %v", err)
+			}
 
 			if len(segments) != len(tc.expected) {
 				t.Errorf("Between(%v, %v) expected %v segments, got %v", tc.from, tc.to, len(tc.expected), len(segments))
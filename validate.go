@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/iem-rd/quote-engine/engine"
+)
+
+// runValidate implements the validate command: check tariff files and report the problems found
+//
+//	go run . validate tariff.yaml other.yaml
+//	go run . validate --json tariff.yaml
+func runValidate(args []string) int {
+	var asJson bool
+
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.BoolVar(&asJson, "json", false, "output the diagnostics as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fail(exitUsage, "missing tariff file")
+	}
+
+	var all engine.Diagnostics
+	for _, filename := range fs.Args() {
+		diags, err := engine.ValidateTariffDefinitionFile(filename)
+		if err != nil {
+			return fail(exitFailure, "failed to read tariff: %v", err)
+		}
+		all = append(all, diags...)
+	}
+
+	if asJson {
+		json, err := all.ToJson()
+		if err != nil {
+			return fail(exitFailure, "failed to convert diagnostics to JSON: %v", err)
+		}
+		fmt.Println(string(json))
+	} else {
+		fmt.Print(all.String())
+	}
+
+	if all.HasErrors() {
+		return exitParseError
+	}
+	return exitOK
+}